@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+)
+
+// pooledClientIdleTimeout is how long a pooled client may go unused before the health check drops it,
+// e.g. after its token was rotated
+const pooledClientIdleTimeout = 30 * time.Minute
+
+// healthCheckPingTimeout is how long the health check waits for each pooled client, so that one unreachable
+// Shipa host doesn't hold up the others
+const healthCheckPingTimeout = 10 * time.Second
+
+// shipaClients is the client pool shared by all events. _main runs its health check.
+var shipaClients = newClientPool()
+
+// clientPool keeps one authenticated Shipa client per host and token, so that events don't pay for
+// an authentication round trip. A changed token gets a new client that is authenticated on first use,
+// clients that fail the health check are re-authenticated on their next use.
+type clientPool struct {
+	// mu guards clients and the client, healthy and lastUsed fields of the pooled clients
+	mu      sync.Mutex
+	clients map[ShipaCredentials]*pooledClient
+	// newClient creates and authenticates a client
+	newClient func(host, token string, opts ...shipa.Option) (*shipa.Client, error)
+	// options configure the clients of the pool
+	options []shipa.Option
+}
+
+type pooledClient struct {
+	// authMu serializes the authentication of events using the same host and token
+	authMu   sync.Mutex
+	client   *shipa.Client
+	healthy  bool
+	lastUsed time.Time
+}
+
+func newClientPool() *clientPool {
+	return &clientPool{
+		clients:   make(map[ShipaCredentials]*pooledClient),
+		newClient: shipa.NewClient,
+		options:   []shipa.Option{shipa.WithUserAgent(ServiceName), shipa.WithRequestObserver(observeShipaRequest)},
+	}
+}
+
+// entry returns the pooled client for the credentials, adding it if it is new
+func (p *clientPool) entry(credentials ShipaCredentials) *pooledClient {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	entry, ok := p.clients[credentials]
+	if !ok {
+		entry = &pooledClient{}
+		p.clients[credentials] = entry
+	}
+	return entry
+}
+
+// healthyClient returns the client of the entry if it is healthy, or nil
+func (p *clientPool) healthyClient(entry *pooledClient) *shipa.Client {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if entry.healthy {
+		return entry.client
+	}
+	return nil
+}
+
+// setHealthy records the health of a client, unless the entry was given a new client in the meantime
+func (p *clientPool) setHealthy(entry *pooledClient, client *shipa.Client, healthy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if entry.client == client {
+		entry.healthy = healthy
+	}
+}
+
+// get returns the pooled client for the credentials, authenticating it if it is new or unhealthy
+func (p *clientPool) get(credentials ShipaCredentials) (*shipa.Client, error) {
+	entry := p.entry(credentials)
+	p.mu.Lock()
+	entry.lastUsed = time.Now()
+	p.mu.Unlock()
+
+	if client := p.healthyClient(entry); client != nil {
+		return client, nil
+	}
+
+	// authentication only blocks events for the same host and token
+	entry.authMu.Lock()
+	defer entry.authMu.Unlock()
+
+	if client := p.healthyClient(entry); client != nil {
+		return client, nil
+	}
+
+	logger.Infow("Authenticating Shipa client", "host", credentials.Host)
+	client, err := p.newClient(credentials.Host, credentials.Token, p.options...)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	entry.client = client
+	entry.healthy = true
+	p.mu.Unlock()
+
+	return client, nil
+}
+
+// check verifies within ctx that the pooled client for the credentials authenticates. Healthy clients are pinged,
+// others are replaced by a client that is pinged in their place, so the check never waits for an authentication
+// of an event.
+func (p *clientPool) check(ctx context.Context, credentials ShipaCredentials) error {
+	entry := p.entry(credentials)
+
+	if client := p.healthyClient(entry); client != nil {
+		err := client.Ping(ctx)
+		if err != nil {
+			p.setHealthy(entry, client, false)
+		}
+		return err
+	}
+
+	options := append(append([]shipa.Option{}, p.options...), shipa.WithoutAuthProbe())
+	client, err := p.newClient(credentials.Host, credentials.Token, options...)
+	if err != nil {
+		return err
+	}
+	if err := client.Ping(ctx); err != nil {
+		return fmt.Errorf("shipa client auth failed: %w", err)
+	}
+
+	p.mu.Lock()
+	if !entry.healthy {
+		entry.client = client
+		entry.healthy = true
+		entry.lastUsed = time.Now()
+	}
+	p.mu.Unlock()
+
+	return nil
+}
+
+// checkHealth probes all pooled clients, marks the failing ones for re-authentication and drops idle ones
+func (p *clientPool) checkHealth(ctx context.Context) {
+	p.mu.Lock()
+	clients := make(map[ShipaCredentials]*pooledClient, len(p.clients))
+	for credentials, entry := range p.clients {
+		if time.Since(entry.lastUsed) > pooledClientIdleTimeout {
+			delete(p.clients, credentials)
+			continue
+		}
+		clients[credentials] = entry
+	}
+	p.mu.Unlock()
+
+	for credentials, entry := range clients {
+		p.mu.Lock()
+		client := entry.client
+		p.mu.Unlock()
+		if client == nil {
+			continue
+		}
+
+		pingCtx, cancel := context.WithTimeout(ctx, healthCheckPingTimeout)
+		err := client.Ping(pingCtx)
+		cancel()
+		if err != nil {
+			logger.Warnw("health check of Shipa client failed, re-authenticating on next use", "host", credentials.Host, "error", err)
+		}
+
+		p.setHealthy(entry, client, err == nil)
+	}
+}
+
+// runHealthChecks checks the health of the pooled clients every interval until ctx is done
+func (p *clientPool) runHealthChecks(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.checkHealth(ctx)
+		}
+	}
+}
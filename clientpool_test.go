@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+)
+
+// Tests that pooled clients are authenticated once and re-authenticated after a changed token or a failed health check
+func TestClientPool(t *testing.T) {
+	healthy := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	authentications := 0
+	pool := newClientPool()
+	pool.newClient = func(host, token string, opts ...shipa.Option) (*shipa.Client, error) {
+		authentications++
+		return &shipa.Client{HostURL: host, HTTPClient: server.Client(), Token: token}, nil
+	}
+
+	credentials := ShipaCredentials{Host: server.URL, Token: "token"}
+	first, err := pool.get(credentials)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	second, err := pool.get(credentials)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if first != second || authentications != 1 {
+		t.Errorf("Expected the client to be reused, but got %d authentications", authentications)
+	}
+
+	if _, err := pool.get(ShipaCredentials{Host: server.URL, Token: "rotated"}); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if authentications != 2 {
+		t.Errorf("Expected a changed token to be authenticated, but got %d authentications", authentications)
+	}
+
+	pool.checkHealth(context.Background())
+	if _, err := pool.get(credentials); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if authentications != 2 {
+		t.Errorf("Expected healthy clients to be reused, but got %d authentications", authentications)
+	}
+
+	healthy = false
+	pool.checkHealth(context.Background())
+	if _, err := pool.get(credentials); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if authentications != 3 {
+		t.Errorf("Expected an unhealthy client to be re-authenticated, but got %d authentications", authentications)
+	}
+
+	if err := pool.check(context.Background(), credentials); err == nil {
+		t.Errorf("Expected the readiness check of a client with a revoked token to fail")
+	}
+	healthy = true
+	if err := pool.check(context.Background(), credentials); err != nil || authentications != 4 {
+		t.Errorf("Expected the failed client to be re-authenticated, but got %v after %d authentications", err, authentications)
+	}
+}
+
+// Tests that the readiness check returns within its context while an event is stuck authenticating the same client
+func TestClientPoolCheckDoesNotWaitForAuthentication(t *testing.T) {
+	requests := make(chan struct{}, 10)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	defer close(release)
+
+	pool := newClientPool()
+	pool.options = append(pool.options, shipa.WithRetryPolicy(shipa.RetryPolicy{}))
+	credentials := ShipaCredentials{Host: server.URL, Token: "token"}
+
+	go pool.get(credentials)
+	<-requests
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- pool.check(ctx, credentials) }()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Expected the check of an unresponsive Shipa host to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected the check to return when its context is done")
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"gopkg.in/yaml.v2"
+)
+
+// credentialsFile is the project or stage resource in the Keptn config repo holding Shipa credentials
+const credentialsFile = "shipa-keptn/credentials.yaml"
+
+// Keys of the mounted Kubernetes secret holding Shipa credentials
+const (
+	credentialsHostKey  = "host"
+	credentialsTokenKey = "token"
+)
+
+var (
+	// shipaCredentials are the default Shipa credentials, set from envConfig at startup
+	shipaCredentials = ShipaCredentials{}
+	// shipaCredentialsDir is the directory the Kubernetes secret with Shipa credentials is mounted at, set from envConfig at startup
+	shipaCredentialsDir = ""
+)
+
+// ShipaCredentials - host and token used to access a Shipa installation
+type ShipaCredentials struct {
+	Host  string `yaml:"host,omitempty"`
+	Token string `yaml:"token,omitempty"`
+}
+
+// credentialsResource - content of shipa-keptn/credentials.yaml. The token is never kept in the config repo,
+// TokenRef is the key of the token in the Kubernetes secret mounted at SHIPA_CREDENTIALS_DIR.
+type credentialsResource struct {
+	Host     string `yaml:"host,omitempty"`
+	TokenRef string `yaml:"tokenRef,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+}
+
+// merge overrides the credentials with the non-empty values of other
+func (c *ShipaCredentials) merge(other *ShipaCredentials) {
+	if other.Host != "" {
+		c.Host = other.Host
+	}
+	if other.Token != "" {
+		c.Token = other.Token
+	}
+}
+
+// mergeResource merges the credentials of a config repo resource, which must reference a token if it changes the host
+// so the token of one Shipa installation is never sent to another
+func (c *ShipaCredentials) mergeResource(other *ShipaCredentials) error {
+	if other.Host != "" && other.Host != c.Host && other.Token == "" {
+		return fmt.Errorf("host %s has no tokenRef, reference its token in the secret mounted at SHIPA_CREDENTIALS_DIR", other.Host)
+	}
+	c.merge(other)
+	return nil
+}
+
+// shipaCredentialsFor returns the Shipa credentials for the event's project and stage, which may be incomplete.
+// Each of the following sources overrides the values of the previous ones:
+// - SHIPA_HOST/SHIPA_TOKEN env vars
+// - the Kubernetes secret mounted at SHIPA_CREDENTIALS_DIR
+// - shipa-keptn/credentials.yaml of the project in the Keptn config repo
+// - shipa-keptn/credentials.yaml of the stage in the Keptn config repo
+func shipaCredentialsFor(myKeptn *keptnv2.Keptn) (*ShipaCredentials, error) {
+	credentials := shipaCredentials
+
+	secret, err := credentialsFromDir(shipaCredentialsDir)
+	if err != nil {
+		return nil, err
+	}
+	credentials.merge(secret)
+
+	if myKeptn != nil && !myKeptn.UseLocalFileSystem {
+		project, stage := myKeptn.Event.GetProject(), myKeptn.Event.GetStage()
+
+		if project != "" {
+			projectCredentials, err := credentialsFromResource(resourceContent(myKeptn.ResourceHandler.GetProjectResource(project, credentialsFile)))
+			if err == nil {
+				err = credentials.mergeResource(projectCredentials)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s of project %s: %w", credentialsFile, project, err)
+			}
+		}
+
+		if project != "" && stage != "" {
+			stageCredentials, err := credentialsFromResource(resourceContent(myKeptn.ResourceHandler.GetStageResource(project, stage, credentialsFile)))
+			if err == nil {
+				err = credentials.mergeResource(stageCredentials)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s of stage %s: %w", credentialsFile, stage, err)
+			}
+		}
+	}
+
+	return &credentials, nil
+}
+
+// credentialsFromDir reads credentials from the files of a mounted Kubernetes secret
+func credentialsFromDir(dir string) (*ShipaCredentials, error) {
+	credentials := &ShipaCredentials{}
+
+	for key, value := range map[string]*string{
+		credentialsHostKey:  &credentials.Host,
+		credentialsTokenKey: &credentials.Token,
+	} {
+		data, err := readSecretKey(dir, key)
+		if err != nil {
+			return nil, err
+		}
+		*value = data
+	}
+
+	return credentials, nil
+}
+
+// readSecretKey returns the value of a key of the Kubernetes secret mounted at dir, or "" if the key does not exist
+func readSecretKey(dir, key string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, key))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read Shipa credentials from %s: %w", dir, err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
+
+// credentialsFromResource parses credentials from a config repo resource, reading the token it references from the
+// mounted Kubernetes secret. A resource with a literal token is rejected.
+func credentialsFromResource(content []byte, found bool, err error) (*ShipaCredentials, error) {
+	credentials := &ShipaCredentials{}
+	if err != nil || !found {
+		return credentials, err
+	}
+
+	resource := &credentialsResource{}
+	if err := yaml.Unmarshal(content, resource); err != nil {
+		return nil, err
+	}
+	if resource.Token != "" {
+		return nil, errors.New("token must not be stored in the config repo, reference a key of the secret mounted at SHIPA_CREDENTIALS_DIR with tokenRef")
+	}
+
+	credentials.Host = resource.Host
+	if resource.TokenRef != "" {
+		token, err := readSecretKey(shipaCredentialsDir, resource.TokenRef)
+		if err != nil {
+			return nil, err
+		}
+		if token == "" {
+			return nil, fmt.Errorf("token %q not found in %s", resource.TokenRef, shipaCredentialsDir)
+		}
+		credentials.Token = token
+	}
+
+	return credentials, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Tests that credentials.yaml references the token in the mounted secret and never holds it in the config repo
+func TestCredentialsFromResource(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "staging-token"), []byte("secret\n"), 0600); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	credentialsDir := shipaCredentialsDir
+	shipaCredentialsDir = dir
+	defer func() { shipaCredentialsDir = credentialsDir }()
+
+	credentials, err := credentialsFromResource([]byte("host: https://staging.shipa.cloud\ntokenRef: staging-token\n"), true, nil)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if credentials.Host != "https://staging.shipa.cloud" || credentials.Token != "secret" {
+		t.Errorf("Unexpected credentials: %+v", credentials)
+	}
+
+	if _, err := credentialsFromResource([]byte("host: https://staging.shipa.cloud\ntoken: secret\n"), true, nil); err == nil {
+		t.Errorf("Expected a literal token to be rejected")
+	}
+	if _, err := credentialsFromResource([]byte("tokenRef: missing-token\n"), true, nil); err == nil {
+		t.Errorf("Expected a reference to a missing token to fail")
+	}
+}
+
+// Tests that a resource changing the host brings its own token, so the inherited one isn't sent to another installation
+func TestMergeResourceCredentials(t *testing.T) {
+	credentials := &ShipaCredentials{Host: "https://target.shipa.cloud", Token: "target-token"}
+
+	if err := credentials.mergeResource(&ShipaCredentials{Host: "https://target.shipa.cloud"}); err != nil {
+		t.Errorf("Error: %s", err)
+	}
+	if err := credentials.mergeResource(&ShipaCredentials{Host: "https://staging.shipa.cloud"}); err == nil {
+		t.Errorf("Expected a host change without tokenRef to be rejected")
+	}
+	if credentials.Host != "https://target.shipa.cloud" || credentials.Token != "target-token" {
+		t.Errorf("Unexpected credentials after a rejected resource: %+v", credentials)
+	}
+
+	if err := credentials.mergeResource(&ShipaCredentials{Host: "https://staging.shipa.cloud", Token: "staging-token"}); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if credentials.Host != "https://staging.shipa.cloud" || credentials.Token != "staging-token" {
+		t.Errorf("Unexpected credentials: %+v", credentials)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	"github.com/google/uuid"
+	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// deduplicator remembers the outcomes of recent events, _main configures it from envConfig
+var deduplicator = newDedupStore(1000, "")
+
+// eventOutcome is what the deduplicator remembers about an event
+type eventOutcome struct {
+	// Done is set once the handler has returned, duplicates of events in flight are dropped
+	Done bool `json:"done"`
+	// Finished is the .finished event sent for the event, which is replayed for duplicates
+	Finished *cloudevents.Event `json:"finished,omitempty"`
+	Updated  time.Time          `json:"updated"`
+}
+
+// dedupStore keeps the outcomes of up to capacity recent events by CloudEvent ID and Keptn context, the oldest
+// are dropped first. If file is set, the outcomes of completed events are persisted to it and survive restarts.
+type dedupStore struct {
+	mu       sync.Mutex
+	outcomes map[string]*eventOutcome
+	order    []string
+	capacity int
+	file     string
+}
+
+func newDedupStore(capacity int, file string) *dedupStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &dedupStore{outcomes: make(map[string]*eventOutcome), capacity: capacity, file: file}
+}
+
+// dedupKey returns the key of an event, its CloudEvent ID and Keptn context
+func dedupKey(myKeptn *keptnv2.Keptn) string {
+	return myKeptn.CloudEvent.ID() + "/" + myKeptn.KeptnContext
+}
+
+// load reads the outcomes persisted to the file of the store. Events that were in flight are forgotten,
+// so that they are handled again when they are redelivered.
+func (d *dedupStore) load() error {
+	if d.file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(d.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	persisted := struct {
+		Keys     []string                 `json:"keys"`
+		Outcomes map[string]*eventOutcome `json:"outcomes"`
+	}{}
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, key := range persisted.Keys {
+		if outcome := persisted.Outcomes[key]; outcome != nil && outcome.Done {
+			d.add(key, outcome)
+		}
+	}
+	return nil
+}
+
+// begin remembers the event as in flight and returns true, or returns false and the outcome remembered for it
+// if it is a duplicate
+func (d *dedupStore) begin(key string) (*eventOutcome, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if outcome, ok := d.outcomes[key]; ok {
+		copied := *outcome
+		return &copied, false
+	}
+	d.add(key, &eventOutcome{Updated: time.Now()})
+	return nil, true
+}
+
+// finished remembers the .finished event sent for the event
+func (d *dedupStore) finished(key string, event cloudevents.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if outcome, ok := d.outcomes[key]; ok {
+		outcome.Finished = &event
+		outcome.Updated = time.Now()
+	}
+}
+
+// complete remembers that the handler of the event has returned. An event that failed without sending a
+// .finished event is forgotten, so that a redelivery is handled again.
+func (d *dedupStore) complete(key string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	outcome, ok := d.outcomes[key]
+	if !ok {
+		return
+	}
+	if err != nil && outcome.Finished == nil {
+		d.remove(key)
+	} else {
+		outcome.Done = true
+		outcome.Updated = time.Now()
+	}
+	d.persist()
+}
+
+// forget forgets an event that wasn't handled
+func (d *dedupStore) forget(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.remove(key)
+	d.persist()
+}
+
+func (d *dedupStore) add(key string, outcome *eventOutcome) {
+	d.outcomes[key] = outcome
+	d.order = append(d.order, key)
+	for len(d.order) > d.capacity {
+		delete(d.outcomes, d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+func (d *dedupStore) remove(key string) {
+	delete(d.outcomes, key)
+	for i, k := range d.order {
+		if k == key {
+			d.order = append(d.order[:i:i], d.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// persist writes the outcomes of completed events to the file of the store, replacing it atomically
+func (d *dedupStore) persist() {
+	if d.file == "" {
+		return
+	}
+
+	keys := make([]string, 0, len(d.order))
+	outcomes := make(map[string]*eventOutcome, len(d.order))
+	for _, key := range d.order {
+		if outcome := d.outcomes[key]; outcome.Done {
+			keys = append(keys, key)
+			outcomes[key] = outcome
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{"keys": keys, "outcomes": outcomes})
+	if err != nil {
+		logger.Errorw("failed to encode deduplication store", "error", err)
+		return
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(d.file), filepath.Base(d.file)+".*")
+	if err == nil {
+		_, err = tmp.Write(data)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Rename(tmp.Name(), d.file)
+		}
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}
+	if err != nil {
+		logger.Errorw("failed to persist deduplication store", "file", d.file, "error", err)
+	}
+}
+
+// dedupSender remembers the .finished events it sends for the event with the key
+type dedupSender struct {
+	key    string
+	store  *dedupStore
+	sender keptn.EventSender
+}
+
+// SendEvent sends the event and remembers it if it is a .finished event
+func (s *dedupSender) SendEvent(event cloudevents.Event) error {
+	err := s.sender.SendEvent(event)
+	if err == nil && strings.HasSuffix(event.Type(), ".finished") {
+		s.store.finished(s.key, event)
+	}
+	return err
+}
+
+// handleDuplicate replays the .finished event remembered for a duplicate as a new CloudEvent instead of handling
+// it again. Duplicates of events in flight, and of events that didn't finish a task, are dropped.
+func handleDuplicate(myKeptn *keptnv2.Keptn, outcome *eventOutcome) error {
+	log := eventLogger(myKeptn)
+
+	switch {
+	case outcome.Finished != nil:
+		log.Infow("Replaying .finished event for duplicate event", "finished_event_id", outcome.Finished.ID())
+		eventsDeduplicated.WithLabelValues("replayed").Inc()
+
+		event := outcome.Finished.Clone()
+		event.SetID(uuid.New().String())
+		event.SetTime(time.Now())
+		return myKeptn.EventSender.SendEvent(event)
+	case !outcome.Done:
+		log.Info("Dropping duplicate of event in flight")
+	default:
+		log.Info("Dropping duplicate of handled event")
+	}
+	eventsDeduplicated.WithLabelValues("dropped").Inc()
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
+)
+
+// Tests that duplicates of events in flight are dropped and duplicates of finished events get the .finished event replayed
+func TestDedupStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "dedup.json")
+	store := newDedupStore(2, file)
+
+	myKeptn, _, err := initializeTestObjects("test-events/deployment.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	sender := myKeptn.EventSender.(*fake.EventSender)
+	key := dedupKey(myKeptn)
+
+	if _, isNew := store.begin(key); !isNew {
+		t.Fatalf("Expected the first delivery to be new")
+	}
+	outcome, isNew := store.begin(key)
+	if isNew {
+		t.Fatalf("Expected the redelivery to be a duplicate")
+	}
+	if err := handleDuplicate(myKeptn, outcome); err != nil || len(sender.SentEvents) != 0 {
+		t.Errorf("Expected the duplicate of an event in flight to be dropped, but got %v and %d events", err, len(sender.SentEvents))
+	}
+
+	handlerKeptn := *myKeptn
+	handlerKeptn.EventSender = &dedupSender{key: key, store: store, sender: sender}
+	handlerKeptn.SendTaskFinishedEvent(&keptnv2.EventData{Status: keptnv2.StatusSucceeded, Result: keptnv2.ResultPass, Message: "deployed"}, ServiceName)
+	store.complete(key, nil)
+
+	// a restart keeps the outcomes of completed events
+	store = newDedupStore(2, file)
+	if err := store.load(); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	outcome, isNew = store.begin(key)
+	if isNew {
+		t.Fatalf("Expected the redelivery to be a duplicate after a restart")
+	}
+	if err := handleDuplicate(myKeptn, outcome); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(sender.SentEvents) != 2 {
+		t.Fatalf("Expected the .finished event to be replayed, but got %d events", len(sender.SentEvents))
+	}
+	original, replayed := sender.SentEvents[0], sender.SentEvents[1]
+	data := &keptnv2.EventData{}
+	replayed.DataAs(data)
+	if replayed.Type() != original.Type() || replayed.ID() == original.ID() || data.Message != "deployed" {
+		t.Errorf("Expected the .finished event to be replayed as a new event, but got %s %s %q", replayed.Type(), replayed.ID(), data.Message)
+	}
+
+	store.begin("failed")
+	store.complete("failed", errors.New("failed"))
+	if _, isNew := store.begin("failed"); !isNew {
+		t.Errorf("Expected an event that failed without .finished event to be handled again")
+	}
+
+	store.begin("newer")
+	if _, isNew := store.begin(key); !isNew {
+		t.Errorf("Expected the oldest event to be dropped beyond the capacity")
+	}
+}
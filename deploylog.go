@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// deployLogTailLines is the number of deploy log lines attached to deployment.finished events
+const deployLogTailLines = 30
+
+// deployLogLabel is the label of deployment.finished events linking to the full Shipa app log
+const deployLogLabel = "Shipa deploy log"
+
+// appLogURL is the link to the log of an app in the Shipa dashboard, with {app} in place of the app name.
+// It is set from envConfig at startup, events link to no dashboard if it is empty.
+var appLogURL = ""
+
+// appLogLink returns the link to the log of an app in the Shipa dashboard, or "" if appLogURL isn't set
+func appLogLink(appName string) string {
+	if appLogURL == "" {
+		return ""
+	}
+	return strings.ReplaceAll(appLogURL, "{app}", url.PathEscape(appName))
+}
+
+// deployLogLabels returns the labels of the deployment.finished events of an app, linking to its log in the Shipa dashboard
+func deployLogLabels(appName string) map[string]string {
+	link := appLogLink(appName)
+	if link == "" {
+		return nil
+	}
+	return map[string]string{deployLogLabel: link}
+}
+
+// logTail keeps the last lines of a deploy log
+type logTail struct {
+	mu    sync.Mutex
+	max   int
+	lines []string
+}
+
+func newLogTail(max int) *logTail {
+	return &logTail{max: max}
+}
+
+// add appends a line, dropping the oldest line when the tail is full. Lines added to a nil tail are discarded.
+func (t *logTail) add(line string) {
+	if t == nil {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.lines = append(t.lines, line)
+	if len(t.lines) > t.max {
+		t.lines = t.lines[len(t.lines)-t.max:]
+	}
+}
+
+func (t *logTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return strings.Join(t.lines, "\n")
+}
+
+// withLogTail appends the deploy log tail to a .finished event message
+func withLogTail(msg string, tail *logTail) string {
+	if tail == nil {
+		return msg
+	}
+
+	lines := tail.String()
+	if lines == "" {
+		return msg
+	}
+
+	return msg + "\n\nDeploy log:\n" + lines
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// maxAppNameLength is the longest app name accepted by the Shipa API
+const maxAppNameLength = 40
+
+// shipaAppName maps a Keptn project/stage/service to the name of the Shipa app it is deployed as
+func shipaAppName(project, stage, service string) string {
+	name := strings.ToLower(fmt.Sprintf("%s-%s-%s", project, stage, service))
+
+	var b strings.Builder
+	for _, r := range name {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('-')
+		}
+	}
+
+	name = b.String()
+	if len(name) > maxAppNameLength {
+		name = name[:maxAppNameLength]
+	}
+
+	return strings.Trim(name, "-")
+}
+
+// deploymentImage returns the image referenced in configurationChange.values.image
+func deploymentImage(data *keptnv2.DeploymentTriggeredEventData) (string, error) {
+	value, ok := data.ConfigurationChange.Values["image"]
+	if !ok {
+		return "", errors.New("no image found in configurationChange.values.image")
+	}
+
+	image, ok := value.(string)
+	if !ok || image == "" {
+		return "", fmt.Errorf("invalid image in configurationChange.values.image: %v", value)
+	}
+
+	return image, nil
+}
+
+func (s *ShipaHandler) deployment(ctx context.Context, myKeptn *keptnv2.Keptn, data *keptnv2.DeploymentTriggeredEventData) error {
+	ctx = shipa.ContextWithLogger(ctx, s.log)
+
+	s.log.Info("1. Send Deployment.Started Cloud-Event")
+	myKeptn.SendTaskStartedEvent(data, ServiceName)
+
+	s.log.Info("2. Deploy image to Shipa")
+	appName := shipaAppName(data.Project, data.Stage, data.Service)
+
+	image, err := deploymentImage(data)
+	if err != nil {
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	steps, err := deploymentStrategy(myKeptn, data)
+	if err != nil {
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	err = s.ensureApp(ctx, appName)
+	if err != nil {
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	// the deploy is not detached, so that Shipa streams the build and rollout log
+	deploy := &shipa.AppDeploy{
+		Image:   image,
+		Message: fmt.Sprintf("Keptn deployment %s", myKeptn.KeptnContext),
+	}
+	steps.apply(deploy)
+
+	deployLog := newLogTail(deployLogTailLines)
+	labels := deployLogLabels(appName)
+	ensureEventLabels(myKeptn)
+
+	deployment, err := s.deploy(ctx, &AppDeployConfig{Name: appName, Deploy: deploy}, statusChangedReporter(myKeptn), deployLog)
+	if err != nil {
+		myKeptn.SendTaskFinishedEvent(&keptnv2.EventData{
+			Status:  keptnv2.StatusErrored,
+			Result:  keptnv2.ResultFailed,
+			Message: withLogTail(err.Error(), deployLog),
+			Labels:  labels,
+		}, ServiceName)
+		return err
+	}
+
+	uris, err := s.deploymentURIs(ctx, appName)
+	if err != nil {
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	s.log.Info("3. Send Deployment.Finished Cloud-Event")
+	myKeptn.SendTaskFinishedEvent(&keptnv2.DeploymentFinishedEventData{
+		EventData: keptnv2.EventData{
+			Status:  keptnv2.StatusSucceeded,
+			Result:  keptnv2.ResultPass,
+			Message: withLogTail(fmt.Sprintf("Successfully deployed %s to Shipa app %s as version %s", image, appName, deployment.Version), deployLog),
+			Labels:  labels,
+		},
+		Deployment: keptnv2.DeploymentFinishedData{
+			DeploymentStrategy:   data.Deployment.DeploymentStrategy,
+			DeploymentURIsLocal:  uris,
+			DeploymentURIsPublic: uris,
+			DeploymentNames:      []string{appName},
+		},
+	}, ServiceName)
+
+	return nil
+}
+
+// ensureApp creates the Shipa app in the framework and team of the stage's target unless it already exists
+func (s *ShipaHandler) ensureApp(ctx context.Context, appName string) error {
+	_, err := s.client.GetApp(ctx, appName)
+	if err == nil {
+		return nil
+	}
+	if !shipa.IsNotFound(err) {
+		s.log.Errorw("failed to get app", "error", err)
+		return err
+	}
+
+	if s.target.Framework == "" {
+		return fmt.Errorf("app %s does not exist and no framework is configured for the stage in %s", appName, targetsFile)
+	}
+
+	app := &shipa.App{Name: appName}
+	s.target.applyDefaults(app)
+	err = s.client.CreateApp(ctx, app)
+	if err != nil {
+		s.log.Errorw("failed to create app", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// deploymentURIs returns the addresses the Shipa app is reachable at
+func (s *ShipaHandler) deploymentURIs(ctx context.Context, appName string) ([]string, error) {
+	app, err := s.client.GetApp(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to get app", "error", err)
+		return nil, err
+	}
+
+	uris := make([]string, 0)
+	for _, entrypoint := range app.Entrypoints {
+		if entrypoint.Cname == "" {
+			continue
+		}
+		scheme := entrypoint.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		uris = append(uris, fmt.Sprintf("%s://%s", scheme, entrypoint.Cname))
+	}
+
+	if len(uris) == 0 && app.IP != "" {
+		uris = append(uris, "http://"+app.IP)
+	}
+
+	return uris, nil
+}
@@ -1,11 +1,19 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/brunoa19/shipa-keptn/shipa"
 	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
@@ -54,7 +62,7 @@ func TestHandleActionTriggeredEvent(t *testing.T) {
 		t.Errorf("Error getting keptn event data")
 	}
 
-	err = HandleActionTriggeredEvent(myKeptn, *incomingEvent, specificEvent)
+	err = HandleActionTriggeredEvent(context.Background(), myKeptn, *incomingEvent, specificEvent)
 	if err != nil {
 		t.Errorf("Error: " + err.Error())
 	}
@@ -77,10 +85,24 @@ func TestHandleActionTriggeredEvent(t *testing.T) {
 	}
 }
 
-// Tests HandleDeploymentTriggeredEvent
-// TODO: Add your test-code
+// newFakeShipaHandler returns a ShipaHandler whose client talks to a fake Shipa API served by mux
+func newFakeShipaHandler(t *testing.T, mux *http.ServeMux) *ShipaHandler {
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	return &ShipaHandler{
+		client: &shipa.Client{
+			HostURL:    server.URL,
+			HTTPClient: server.Client(),
+			Token:      "test-token",
+		},
+		log: logger,
+	}
+}
+
+// Tests the deployment of a deployment.triggered event through Shipa
 func TestHandleDeploymentTriggeredEvent(t *testing.T) {
-	myKeptn, incomingEvent, err := initializeTestObjects("test-events/evaluation.triggered.json")
+	myKeptn, incomingEvent, err := initializeTestObjects("test-events/deployment.triggered.json")
 	if err != nil {
 		t.Error(err)
 		return
@@ -92,10 +114,255 @@ func TestHandleDeploymentTriggeredEvent(t *testing.T) {
 		t.Errorf("Error getting keptn event data")
 	}
 
-	err = HandleDeploymentTriggeredEvent(myKeptn, *incomingEvent, specificEvent)
+	deploymentPollInterval = time.Millisecond
+	appLogURL = "https://dashboard.shipa.cloud/apps/{app}/logs"
+	defer func() { appLogURL = "" }()
+
+	var deployedImage, deployedSteps string
+	deployments := []*shipa.AppDeployment{{ID: "1", Version: "1", CanRollback: true}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-dev-carts/deploy", func(w http.ResponseWriter, r *http.Request) {
+		deployedImage = r.FormValue("image")
+		deployedSteps = r.FormValue("steps")
+		deployments = append([]*shipa.AppDeployment{{ID: "2", Version: "2", Active: true}}, deployments...)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprintln(w, `{"Message":"Pulling image docker.io/keptnexamples/carts:0.11.2\n"}`)
+		fmt.Fprintln(w, `{"Message":"Rollout of version 2 completed\n"}`)
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(deployments)
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&shipa.App{
+			Name:  "sockshop-dev-carts",
+			IP:    "carts.shipa.cloud",
+			Units: []*shipa.Unit{{ID: "u1", Version: "2", Status: "started"}},
+		})
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	err = handler.deployment(context.Background(), myKeptn, specificEvent)
 	if err != nil {
 		t.Errorf("Error: " + err.Error())
 	}
+
+	if deployedImage != "docker.io/keptnexamples/carts:0.11.2" {
+		t.Errorf("Expected image docker.io/keptnexamples/carts:0.11.2 to be deployed, but got %q", deployedImage)
+	}
+
+	// blue_green_service is rolled out in multiple steps
+	if deployedSteps != "2" {
+		t.Errorf("Expected a deployment in 2 steps, but got %q", deployedSteps)
+	}
+
+	// Verify that a .started, at least one .status.changed and a .finished event have been sent
+	sentEvents := myKeptn.EventSender.(*fake.EventSender).SentEvents
+	if len(sentEvents) < 3 {
+		t.Fatalf("Expected at least three events to be sent, but got %v", len(sentEvents))
+	}
+
+	if keptnv2.GetStartedEventType(keptnv2.DeploymentTaskName) != sentEvents[0].Type() {
+		t.Errorf("Expected a deployment.started event type")
+	}
+
+	if keptnv2.GetStatusChangedEventType(keptnv2.DeploymentTaskName) != sentEvents[1].Type() {
+		t.Errorf("Expected a deployment.status.changed event type")
+	}
+
+	finished := sentEvents[len(sentEvents)-1]
+	if keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName) != finished.Type() {
+		t.Errorf("Expected a deployment.finished event type")
+	}
+
+	finishedData := &keptnv2.DeploymentFinishedEventData{}
+	if err := finished.DataAs(finishedData); err != nil {
+		t.Fatalf("Error getting deployment.finished event data: %s", err)
+	}
+
+	if finishedData.Status != keptnv2.StatusSucceeded {
+		t.Errorf("Expected status %s, but got %s", keptnv2.StatusSucceeded, finishedData.Status)
+	}
+
+	if !strings.Contains(finishedData.Message, "Rollout of version 2 completed") {
+		t.Errorf("Expected the deploy log tail in the message, but got %q", finishedData.Message)
+	}
+
+	if link := finishedData.Labels[deployLogLabel]; link != "https://dashboard.shipa.cloud/apps/sockshop-dev-carts/logs" {
+		t.Errorf("Expected a %q label linking to the dashboard, but got %q", deployLogLabel, link)
+	}
+
+	if len(finishedData.Deployment.DeploymentURIsPublic) != 1 || finishedData.Deployment.DeploymentURIsPublic[0] != "http://carts.shipa.cloud" {
+		t.Errorf("Unexpected deployment URIs: %v", finishedData.Deployment.DeploymentURIsPublic)
+	}
+}
+
+// Tests the rollback of a rollback.triggered event to the previous Shipa deployment
+func TestHandleRollbackTriggeredEvent(t *testing.T) {
+	myKeptn, incomingEvent, err := initializeTestObjects("test-events/rollback.triggered.json")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	specificEvent := &keptnv2.RollbackTriggeredEventData{}
+	err = incomingEvent.DataAs(specificEvent)
+	if err != nil {
+		t.Errorf("Error getting keptn event data")
+	}
+
+	deploymentPollInterval = time.Millisecond
+
+	var rollbackImage string
+	deployments := []*shipa.AppDeployment{
+		{ID: "4", Version: "4", Image: "carts:0.11.4", Error: "failed to start", CanRollback: true},
+		{ID: "3", Version: "3", Image: "carts:0.11.3", Active: true, CanRollback: true},
+		{ID: "2", Version: "2", Image: "carts:0.11.2", Error: "crashed", CanRollback: true},
+		{ID: "1", Version: "1", Image: "carts:0.11.1", CanRollback: true},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-staging-carts/deploy/rollback", func(w http.ResponseWriter, r *http.Request) {
+		rollbackImage = r.FormValue("image")
+		deployments = append([]*shipa.AppDeployment{{ID: "5", Version: "5", Image: rollbackImage, Active: true}}, deployments...)
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/apps/sockshop-staging-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(deployments)
+	})
+	mux.HandleFunc("/apps/sockshop-staging-carts", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&shipa.App{
+			Name:  "sockshop-staging-carts",
+			Units: []*shipa.Unit{{ID: "u1", Version: "5", Status: "started"}},
+		})
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	err = handler.rollback(context.Background(), myKeptn, specificEvent)
+	if err != nil {
+		t.Errorf("Error: " + err.Error())
+	}
+
+	// the failed deployment 4 after the active one and the failed deployment 2 are skipped
+	if rollbackImage != "carts:0.11.1" {
+		t.Errorf("Expected a rollback to carts:0.11.1, but got %q", rollbackImage)
+	}
+
+	sentEvents := myKeptn.EventSender.(*fake.EventSender).SentEvents
+	finished := sentEvents[len(sentEvents)-1]
+	if keptnv2.GetFinishedEventType(keptnv2.RollbackTaskName) != finished.Type() {
+		t.Fatalf("Expected a rollback.finished event type")
+	}
+
+	finishedData := &keptnv2.RollbackFinishedEventData{}
+	if err := finished.DataAs(finishedData); err != nil {
+		t.Fatalf("Error getting rollback.finished event data: %s", err)
+	}
+
+	if finishedData.Status != keptnv2.StatusSucceeded || !strings.Contains(finishedData.Message, "version 1") {
+		t.Errorf("Unexpected rollback.finished event: %s %q", finishedData.Status, finishedData.Message)
+	}
+
+	// an app the release task of the Keptn context already reverted isn't rolled back again
+	deployments = append([]*shipa.AppDeployment{{
+		ID: "6", Version: "6", Image: "carts:0.11.1", Active: true, Origin: originRollback,
+		Message: revertReason(keptnv2.GetTriggeredEventType(keptnv2.ReleaseTaskName), myKeptn.KeptnContext),
+	}}, deployments...)
+	rollbackImage = ""
+
+	err = handler.rollback(context.Background(), myKeptn, specificEvent)
+	if err != nil {
+		t.Errorf("Error: " + err.Error())
+	}
+	if rollbackImage != "" {
+		t.Errorf("Expected no rollback of the reverted app, but got one to %q", rollbackImage)
+	}
+	sentEvents = myKeptn.EventSender.(*fake.EventSender).SentEvents
+	if err := sentEvents[len(sentEvents)-1].DataAs(finishedData); err != nil {
+		t.Fatalf("Error getting rollback.finished event data: %s", err)
+	}
+	if finishedData.Status != keptnv2.StatusSucceeded || !strings.Contains(finishedData.Message, "already rolled back") {
+		t.Errorf("Unexpected rollback.finished event: %s %q", finishedData.Status, finishedData.Message)
+	}
+}
+
+// Tests that release.triggered reverts the rollout after a failed evaluation, and that the rollback of the same
+// Keptn context doesn't roll the app back once more
+func TestReleaseRevertsFailedEvaluation(t *testing.T) {
+	myKeptn, incomingEvent, err := initializeTestObjects("test-events/release.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	specificEvent := &keptnv2.ReleaseTriggeredEventData{}
+	if err := incomingEvent.DataAs(specificEvent); err != nil {
+		t.Fatalf("Error getting keptn event data: %s", err)
+	}
+	specificEvent.Result = keptnv2.ResultFailed
+
+	deploymentPollInterval = time.Millisecond
+
+	var rollbacks []string
+	units := []*shipa.Unit{{ID: "u2", Version: "2", Status: "started"}}
+	deployments := []*shipa.AppDeployment{
+		{ID: "2", Version: "2", Image: "carts:0.11.2", Active: true, CanRollback: true},
+		{ID: "1", Version: "1", Image: "carts:0.11.1", CanRollback: true},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-dev-carts/deploy/rollback", func(w http.ResponseWriter, r *http.Request) {
+		rollbacks = append(rollbacks, r.FormValue("image"))
+		deployments[0].Active = false
+		version := strconv.Itoa(len(deployments) + 1)
+		deployments = append([]*shipa.AppDeployment{{
+			ID: version, Version: version, Image: r.FormValue("image"), Active: true, Origin: r.FormValue("origin"), Message: r.FormValue("reason"),
+		}}, deployments...)
+		units = []*shipa.Unit{{ID: "u" + version, Version: version, Status: "started"}}
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(deployments)
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&shipa.App{Name: "sockshop-dev-carts", Units: units})
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	if err := handler.release(context.Background(), myKeptn, specificEvent); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(rollbacks) != 1 || rollbacks[0] != "carts:0.11.1" {
+		t.Fatalf("Expected a rollback to carts:0.11.1, but got %v", rollbacks)
+	}
+
+	sender := myKeptn.EventSender.(*fake.EventSender)
+	finishedData := &keptnv2.ReleaseFinishedEventData{}
+	if err := sender.SentEvents[len(sender.SentEvents)-1].DataAs(finishedData); err != nil {
+		t.Fatalf("Error getting release.finished event data: %s", err)
+	}
+	if finishedData.Result != keptnv2.ResultFailed || !strings.Contains(finishedData.Message, "reverted to version 1") {
+		t.Errorf("Unexpected release.finished event: %s %q", finishedData.Result, finishedData.Message)
+	}
+
+	rollback := &keptnv2.RollbackTriggeredEventData{EventData: specificEvent.EventData}
+	if err := handler.rollback(context.Background(), myKeptn, rollback); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(rollbacks) != 1 {
+		t.Errorf("Expected the reverted app not to be rolled back again, but got %v", rollbacks)
+	}
+	rollbackData := &keptnv2.RollbackFinishedEventData{}
+	if err := sender.SentEvents[len(sender.SentEvents)-1].DataAs(rollbackData); err != nil {
+		t.Fatalf("Error getting rollback.finished event data: %s", err)
+	}
+	if !strings.Contains(rollbackData.Message, "already rolled back") {
+		t.Errorf("Unexpected rollback.finished event: %q", rollbackData.Message)
+	}
+
+	// the rollback of another Keptn context rolls the app back
+	myKeptn.KeptnContext = "another-context"
+	if err := handler.rollback(context.Background(), myKeptn, rollback); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(rollbacks) != 2 {
+		t.Errorf("Expected the rollback of another Keptn context to roll the app back, but got %v", rollbacks)
+	}
 }
 
 // Tests HandleEvaluationTriggeredEvent
@@ -113,7 +380,7 @@ func TestHandleEvaluationTriggeredEvent(t *testing.T) {
 		t.Errorf("Error getting keptn event data")
 	}
 
-	err = HandleEvaluationTriggeredEvent(myKeptn, *incomingEvent, specificEvent)
+	err = HandleEvaluationTriggeredEvent(context.Background(), myKeptn, *incomingEvent, specificEvent)
 	if err != nil {
 		t.Errorf("Error: " + err.Error())
 	}
@@ -134,7 +401,23 @@ func TestHandleGetSliTriggered(t *testing.T) {
 		t.Errorf("Error getting keptn event data")
 	}
 
-	err = HandleGetSliTriggeredEvent(myKeptn, *incomingEvent, specificEvent)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-staging-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*shipa.AppDeployment{{ID: "1", Version: "1", Active: true}})
+	})
+	mux.HandleFunc("/apps/sockshop-staging-carts", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&shipa.App{
+			Name: "sockshop-staging-carts",
+			Units: []*shipa.Unit{
+				{ID: "u1", Version: "1", Status: "started", Restarts: 2},
+				{ID: "u2", Version: "1", Status: "started", Restarts: 1},
+				{ID: "u3", Version: "1", Status: "error"},
+			},
+		})
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	err = handler.getSLI(context.Background(), myKeptn, specificEvent)
 	if err != nil {
 		t.Errorf("Error: " + err.Error())
 	}
@@ -155,10 +438,30 @@ func TestHandleGetSliTriggered(t *testing.T) {
 	if keptnv2.GetFinishedEventType(keptnv2.GetSLITaskName) != myKeptn.EventSender.(*fake.EventSender).SentEvents[1].Type() {
 		t.Errorf("Expected a get-sli.finished event type")
 	}
+
+	finishedData := &keptnv2.GetSLIFinishedEventData{}
+	if err := myKeptn.EventSender.(*fake.EventSender).SentEvents[1].DataAs(finishedData); err != nil {
+		t.Fatalf("Error getting get-sli.finished event data: %s", err)
+	}
+
+	expected := map[string]*keptnv2.SLIResult{
+		"units_started":     {Metric: "units_started", Value: 2, Success: true},
+		"unit_restarts":     {Metric: "unit_restarts", Value: 3, Success: true},
+		"some_other_metric": {Metric: "some_other_metric", Success: false},
+	}
+	for _, result := range finishedData.GetSLI.IndicatorValues {
+		want, ok := expected[result.Metric]
+		if !ok {
+			t.Errorf("Unexpected indicator %s", result.Metric)
+			continue
+		}
+		if result.Value != want.Value || result.Success != want.Success {
+			t.Errorf("Expected %s to be %v (success=%v), but got %v (success=%v)", result.Metric, want.Value, want.Success, result.Value, result.Success)
+		}
+	}
 }
 
-// Tests the HandleReleaseTriggeredEvent Handler
-// TODO: Add your test-code
+// Tests that release.triggered waits for a multi-step Shipa rollout to finish
 func TestHandleReleaseTriggeredEvent(t *testing.T) {
 	myKeptn, incomingEvent, err := initializeTestObjects("test-events/release.triggered.json")
 	if err != nil {
@@ -172,8 +475,112 @@ func TestHandleReleaseTriggeredEvent(t *testing.T) {
 		t.Errorf("Error getting keptn event data")
 	}
 
-	err = HandleReleaseTriggeredEvent(myKeptn, *incomingEvent, specificEvent)
+	deploymentPollInterval = time.Millisecond
+
+	appRequests := 0
+	deployments := []*shipa.AppDeployment{
+		{ID: "3", Version: "3", Image: "carts:0.11.3", Error: "failed to start", CanRollback: true},
+		{ID: "2", Version: "2", Image: "carts:0.11.2", Active: true, CanRollback: true},
+		{ID: "1", Version: "1", Image: "carts:0.11.1", CanRollback: true},
+	}
+	units := []*shipa.Unit{{ID: "u1", Version: "1", Status: "started"}, {ID: "u2", Version: "2", Status: "started"}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-dev-carts/deploy", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected the rollout to be waited for instead of a new deployment")
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(deployments)
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts", func(w http.ResponseWriter, r *http.Request) {
+		// Shipa moves the remaining traffic to the new version after a few polls
+		if appRequests++; appRequests > 3 {
+			units = []*shipa.Unit{{ID: "u2", Version: "2", Status: "started"}}
+		}
+		json.NewEncoder(w).Encode(&shipa.App{Name: "sockshop-dev-carts", Units: units})
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	err = handler.release(context.Background(), myKeptn, specificEvent)
 	if err != nil {
 		t.Errorf("Error: " + err.Error())
 	}
+
+	// the rollout of the active version 2 is waited for, not the errored deployment 3
+	if appRequests <= 3 {
+		t.Errorf("Expected the release to wait for the old units to go, but got %d app requests", appRequests)
+	}
+
+	sentEvents := myKeptn.EventSender.(*fake.EventSender).SentEvents
+	finished := sentEvents[len(sentEvents)-1]
+	if keptnv2.GetFinishedEventType(keptnv2.ReleaseTaskName) != finished.Type() {
+		t.Fatalf("Expected a release.finished event type")
+	}
+
+	finishedData := &keptnv2.ReleaseFinishedEventData{}
+	if err := finished.DataAs(finishedData); err != nil {
+		t.Fatalf("Error getting release.finished event data: %s", err)
+	}
+
+	if finishedData.Result != keptnv2.ResultPass || !strings.Contains(finishedData.Message, "version 2") {
+		t.Errorf("Unexpected release.finished event: %s %q", finishedData.Result, finishedData.Message)
+	}
+}
+
+// Tests that create.framework succeeds for a framework that already exists
+func TestCreateFrameworkAlreadyExists(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pools-config", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprint(w, `{"Error":"pool already exists"}`)
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	err := handler.createFramework(context.Background(), []byte(`{"shipaFramework":"keptn-dev"}`))
+	if err != nil {
+		t.Errorf("Expected an existing framework to be skipped, but got: %s", err)
+	}
+}
+
+// Tests that a rollout fails instead of waiting for an existing deployment if the deployments can't be listed
+func TestRolloutListDeploymentsFails(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-dev-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"Error":"invalid request"}`)
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	started := false
+	_, err := handler.rollout(context.Background(), "sockshop-dev-carts", func(string) {}, func(ctx context.Context) error {
+		started = true
+		return nil
+	})
+	if err == nil || started {
+		t.Errorf("Expected the rollout to fail before starting, but got %v", err)
+	}
+}
+
+// Tests that a wait for a deployment canceled on shutdown is reported as interrupted, not as timed out
+func TestWaitForDeploymentCanceled(t *testing.T) {
+	deploymentPollInterval = time.Millisecond
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-dev-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*shipa.AppDeployment{{ID: "1", Version: "1", Active: true}})
+	})
+	handler := newFakeShipaHandler(t, mux)
+	known := map[string]bool{"1": true}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := handler.waitForDeployment(ctx, "sockshop-dev-carts", known, func(string) {})
+	if err == nil || !errors.Is(err, context.Canceled) || strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected an interrupted wait, but got %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = handler.waitForDeployment(ctx, "sockshop-dev-carts", known, func(string) {})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timed out wait, but got %v", err)
+	}
 }
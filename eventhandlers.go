@@ -3,12 +3,13 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/brunoa19/shipa-keptn/shipa"
 	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
 	keptn "github.com/keptn/go-utils/pkg/lib"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
 )
 
 /**
@@ -16,174 +17,230 @@ import (
 * See https://github.com/keptn/spec/blob/0.8.0-alpha/cloudevents.md for details on the payload
 **/
 
+func init() {
+	// .triggered events of the Keptn tasks this service implements, or logs
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.DeploymentTaskName),
+		func() interface{} { return &keptnv2.DeploymentTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleDeploymentTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.DeploymentTriggeredEventData))
+		},
+		ownsTask(), withValidator(requireProperties("project", "stage", "service")))
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.TestTaskName),
+		func() interface{} { return &keptnv2.TestTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleTestTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.TestTriggeredEventData))
+		})
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.ApprovalTaskName),
+		func() interface{} { return &keptnv2.ApprovalTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleApprovalTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.ApprovalTriggeredEventData))
+		})
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.EvaluationTaskName),
+		func() interface{} { return &keptnv2.EvaluationTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleEvaluationTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.EvaluationTriggeredEventData))
+		})
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.ReleaseTaskName),
+		func() interface{} { return &keptnv2.ReleaseTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleReleaseTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.ReleaseTriggeredEventData))
+		},
+		ownsTask(), withValidator(requireProperties("project", "stage", "service")))
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.RollbackTaskName),
+		func() interface{} { return &keptnv2.RollbackTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleRollbackTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.RollbackTriggeredEventData))
+		},
+		ownsTask(), withValidator(requireProperties("project", "stage", "service")))
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.ActionTaskName),
+		func() interface{} { return &keptnv2.ActionTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleActionTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.ActionTriggeredEventData))
+		},
+		ownsTask(), withValidator(requireProperties("project", "stage", "service")))
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.GetSLITaskName),
+		func() interface{} { return &keptnv2.GetSLITriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleGetSliTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.GetSLITriggeredEventData))
+		},
+		ownsTask(isShipaSLIProvider), withValidator(func(event cloudevents.Event, data interface{}) error {
+			if !isShipaSLIProvider(data) {
+				return nil
+			}
+			return requireProperties("project", "stage", "service")(event, data)
+		}))
+	eventHandlers.register(keptnv2.GetTriggeredEventType(keptnv2.ConfigureMonitoringTaskName),
+		func() interface{} { return &keptnv2.ConfigureMonitoringTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleConfigureMonitoringTriggeredEvent(ctx, myKeptn, event, data.(*keptnv2.ConfigureMonitoringTriggeredEventData))
+		})
+
+	// events of Keptn 0.7, kept for compatibility
+	eventHandlers.register(keptn.ProblemEventType, // e.g. sent by Dynatrace to the Keptn API
+		func() interface{} { return &keptn.ProblemEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return HandleProblemEvent(ctx, myKeptn, event, data.(*keptn.ProblemEventData))
+		})
+	eventHandlers.register(keptn.ConfigureMonitoringEventType,
+		func() interface{} { return &keptn.ConfigureMonitoringEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			return OldHandleConfigureMonitoringEvent(ctx, myKeptn, event, data.(*keptn.ConfigureMonitoringEventData))
+		})
+
+	// the other events of the Keptn tasks are only logged
+	logged := map[string]func() interface{}{
+		keptnv2.GetStartedEventType(keptnv2.ProjectCreateTaskName):        func() interface{} { return &keptnv2.ProjectCreateStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.ProjectCreateTaskName):       func() interface{} { return &keptnv2.ProjectCreateFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.ServiceCreateTaskName):        func() interface{} { return &keptnv2.ServiceCreateStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.ServiceCreateTaskName):       func() interface{} { return &keptnv2.ServiceCreateFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.ApprovalTaskName):             func() interface{} { return &keptnv2.ApprovalStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.ApprovalTaskName):            func() interface{} { return &keptnv2.ApprovalFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.DeploymentTaskName):           func() interface{} { return &keptnv2.DeploymentStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName):          func() interface{} { return &keptnv2.DeploymentFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.TestTaskName):                 func() interface{} { return &keptnv2.TestStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.TestTaskName):                func() interface{} { return &keptnv2.TestFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.EvaluationTaskName):           func() interface{} { return &keptnv2.EvaluationStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.EvaluationTaskName):          func() interface{} { return &keptnv2.EvaluationFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.ReleaseTaskName):              func() interface{} { return &keptnv2.ReleaseStartedEventData{} },
+		keptnv2.GetStatusChangedEventType(keptnv2.ReleaseTaskName):        func() interface{} { return &keptnv2.ReleaseStatusChangedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.ReleaseTaskName):             func() interface{} { return &keptnv2.ReleaseFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.RollbackTaskName):             func() interface{} { return &keptnv2.RollbackStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.RollbackTaskName):            func() interface{} { return &keptnv2.RollbackFinishedEventData{} },
+		keptnv2.GetTriggeredEventType(keptnv2.GetActionTaskName):          func() interface{} { return &keptnv2.GetActionTriggeredEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.GetActionTaskName):            func() interface{} { return &keptnv2.GetActionStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.GetActionTaskName):           func() interface{} { return &keptnv2.GetActionFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.ActionTaskName):               func() interface{} { return &keptnv2.ActionStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.ActionTaskName):              func() interface{} { return &keptnv2.ActionFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.GetSLITaskName):               func() interface{} { return &keptnv2.GetSLIStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.GetSLITaskName):              func() interface{} { return &keptnv2.GetSLIFinishedEventData{} },
+		keptnv2.GetStartedEventType(keptnv2.ConfigureMonitoringTaskName):  func() interface{} { return &keptnv2.ConfigureMonitoringStartedEventData{} },
+		keptnv2.GetFinishedEventType(keptnv2.ConfigureMonitoringTaskName): func() interface{} { return &keptnv2.ConfigureMonitoringFinishedEventData{} },
+	}
+	for eventType, newData := range logged {
+		eventHandlers.register(eventType, newData, logEvent)
+	}
+}
+
 // GenericLogKeptnCloudEventHandler is a generic handler for Keptn Cloud Events that logs the CloudEvent
 func GenericLogKeptnCloudEventHandler(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data interface{}) error {
-	log.Printf("Handling %s Event: %s", incomingEvent.Type(), incomingEvent.Context.GetID())
-	log.Printf("CloudEvent %T: %v", data, data)
+	eventLogger(myKeptn).Infof("Handling %s Event", incomingEvent.Type())
+	eventLogger(myKeptn).Infof("CloudEvent %T: %v", data, data)
 
 	return nil
 }
 
 // OldHandleConfigureMonitoringEvent handles old configure-monitoring events
 // TODO: add in your handler code
-func OldHandleConfigureMonitoringEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptn.ConfigureMonitoringEventData) error {
-	log.Printf("Handling old configure-monitoring Event: %s", incomingEvent.Context.GetID())
+func OldHandleConfigureMonitoringEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptn.ConfigureMonitoringEventData) error {
+	eventLogger(myKeptn).Info("Handling old configure-monitoring Event")
 
 	return nil
 }
 
 // HandleConfigureMonitoringTriggeredEvent handles configure-monitoring.triggered events
 // TODO: add in your handler code
-func HandleConfigureMonitoringTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ConfigureMonitoringTriggeredEventData) error {
-	log.Printf("Handling configure-monitoring.triggered Event: %s", incomingEvent.Context.GetID())
+func HandleConfigureMonitoringTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ConfigureMonitoringTriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling configure-monitoring.triggered Event")
 
 	return nil
 }
 
-// HandleDeploymentTriggeredEvent handles deployment.triggered events
-// TODO: add in your handler code
-func HandleDeploymentTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.DeploymentTriggeredEventData) error {
-	log.Printf("Handling deployment.triggered Event: %s", incomingEvent.Context.GetID())
+// HandleDeploymentTriggeredEvent handles deployment.triggered events by deploying the new image through Shipa
+func HandleDeploymentTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.DeploymentTriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling deployment.triggered Event")
 
-	return nil
+	handler, err := NewShipaHandler(myKeptn)
+	if err != nil {
+		myKeptn.SendTaskStartedEvent(data, ServiceName)
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	return handler.deployment(ctx, myKeptn, data)
 }
 
 // HandleTestTriggeredEvent handles test.triggered events
 // TODO: add in your handler code
-func HandleTestTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.TestTriggeredEventData) error {
-	log.Printf("Handling test.triggered Event: %s", incomingEvent.Context.GetID())
+func HandleTestTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.TestTriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling test.triggered Event")
 
 	return nil
 }
 
 // HandleApprovalTriggeredEvent handles approval.triggered events
 // TODO: add in your handler code
-func HandleApprovalTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ApprovalTriggeredEventData) error {
-	log.Printf("Handling approval.triggered Event: %s", incomingEvent.Context.GetID())
+func HandleApprovalTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ApprovalTriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling approval.triggered Event")
 
 	return nil
 }
 
 // HandleEvaluationTriggeredEvent handles evaluation.triggered events
 // TODO: add in your handler code
-func HandleEvaluationTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.EvaluationTriggeredEventData) error {
-	log.Printf("Handling evaluation.triggered Event: %s", incomingEvent.Context.GetID())
+func HandleEvaluationTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.EvaluationTriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling evaluation.triggered Event")
 
 	return nil
 }
 
-// HandleReleaseTriggeredEvent handles release.triggered events
-// TODO: add in your handler code
-func HandleReleaseTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ReleaseTriggeredEventData) error {
-	log.Printf("Handling release.triggered Event: %s", incomingEvent.Context.GetID())
-
-	return nil
-}
-
-// HandleGetSliTriggeredEvent handles get-sli.triggered events if SLIProvider == shipa-keptn
-// This function acts as an example showing how to handle get-sli events by sending .started and .finished events
-// TODO: adapt handler code to your needs
-func HandleGetSliTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.GetSLITriggeredEventData) error {
-	log.Printf("Handling get-sli.triggered Event: %s", incomingEvent.Context.GetID())
-
-	// Step 1 - Do we need to do something?
-	// Lets make sure we are only processing an event that really belongs to our SLI Provider
-	if data.GetSLI.SLIProvider != "shipa-keptn" {
-		log.Printf("Not handling get-sli event as it is meant for %s", data.GetSLI.SLIProvider)
-		return nil
-	}
-
-	// Step 2 - Send out a get-sli.started CloudEvent
-	// The get-sli.started cloud-event is new since Keptn 0.8.0 and is required to be send when the task is started
-	_, err := myKeptn.SendTaskStartedEvent(data, ServiceName)
+// HandleReleaseTriggeredEvent handles release.triggered events by finishing the Shipa rollout,
+// or by reverting it if the sequence has failed so far
+func HandleReleaseTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ReleaseTriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling release.triggered Event")
 
+	handler, err := NewShipaHandler(myKeptn)
 	if err != nil {
-		errMsg := fmt.Sprintf("Failed to send task started CloudEvent (%s), aborting...", err.Error())
-		log.Println(errMsg)
-		return err
+		myKeptn.SendTaskStartedEvent(data, ServiceName)
+		return sendTaskErrored(myKeptn, err)
 	}
 
-	// Step 4 - prep-work
-	// Get any additional input / configuration data
-	// - Labels: get the incoming labels for potential config data and use it to pass more labels on result, e.g: links
-	// - SLI.yaml: if your service uses SLI.yaml to store query definitions for SLIs get that file from Keptn
-	labels := data.Labels
-	if labels == nil {
-		labels = make(map[string]string)
-	}
-	testRunID := labels["testRunId"]
+	return handler.release(ctx, myKeptn, data)
+}
 
-	// Step 5 - get SLI Config File
-	// Get SLI File from shipa-keptn subdirectory of the config repo - to add the file use:
-	//   keptn add-resource --project=PROJECT --stage=STAGE --service=SERVICE --resource=my-sli-config.yaml  --resourceUri=shipa-keptn/sli.yaml
-	sliFile := "shipa-keptn/sli.yaml"
-	sliConfigFileContent, err := myKeptn.GetKeptnResource(sliFile)
+// HandleRollbackTriggeredEvent handles rollback.triggered events by rolling the Shipa app back to its previous deployment
+func HandleRollbackTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.RollbackTriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling rollback.triggered Event")
 
-	// FYI you do not need to "fail" if sli.yaml is missing, you can also assume smart defaults like we do
-	// in keptn-contrib/dynatrace-service and keptn-contrib/prometheus-service
+	handler, err := NewShipaHandler(myKeptn)
 	if err != nil {
-		// failed to fetch sli config file
-		errMsg := fmt.Sprintf("Failed to fetch SLI file %s from config repo: %s", sliFile, err.Error())
-		log.Println(errMsg)
-		// send a get-sli.finished event with status=error and result=failed back to Keptn
-
-		_, err = myKeptn.SendTaskFinishedEvent(&keptnv2.EventData{
-			Status: keptnv2.StatusErrored,
-			Result: keptnv2.ResultFailed,
-		}, ServiceName)
-
-		return err
+		myKeptn.SendTaskStartedEvent(data, ServiceName)
+		return sendTaskErrored(myKeptn, err)
 	}
 
-	fmt.Println(sliConfigFileContent)
-
-	// Step 6 - do your work - iterate through the list of requested indicators and return their values
-	// Indicators: this is the list of indicators as requested in the SLO.yaml
-	// SLIResult: this is the array that will receive the results
-	indicators := data.GetSLI.Indicators
-	sliResults := []*keptnv2.SLIResult{}
+	return handler.rollback(ctx, myKeptn, data)
+}
 
-	for _, indicatorName := range indicators {
-		sliResult := &keptnv2.SLIResult{
-			Metric: indicatorName,
-			Value:  123.4, // ToDo: Fetch the values from your monitoring tool here
-		}
-		sliResults = append(sliResults, sliResult)
-	}
+// isShipaSLIProvider reports whether the payload of a get-sli.triggered event asks this service for the SLIs
+func isShipaSLIProvider(data interface{}) bool {
+	getSLI, ok := data.(*keptnv2.GetSLITriggeredEventData)
+	return ok && getSLI.GetSLI.SLIProvider == "shipa-keptn"
+}
 
-	// Step 7 - add additional context via labels (e.g., a backlink to the monitoring or CI tool)
-	labels["Link to Data Source"] = "https://mydatasource/myquery?testRun=" + testRunID
+// HandleGetSliTriggeredEvent handles get-sli.triggered events if SLIProvider == shipa-keptn
+// The indicators are computed from the Shipa app and its deployments, using the queries in shipa-keptn/sli.yaml
+func HandleGetSliTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.GetSLITriggeredEventData) error {
+	eventLogger(myKeptn).Info("Handling get-sli.triggered Event")
 
-	// Step 8 - Build get-sli.finished event data
-	getSliFinishedEventData := &keptnv2.GetSLIFinishedEventData{
-		EventData: keptnv2.EventData{
-			Status: keptnv2.StatusSucceeded,
-			Result: keptnv2.ResultPass,
-		},
-		GetSLI: keptnv2.GetSLIFinished{
-			IndicatorValues: sliResults,
-			Start:           data.GetSLI.Start,
-			End:             data.GetSLI.End,
-		},
+	// Lets make sure we are only processing an event that really belongs to our SLI Provider
+	if data.GetSLI.SLIProvider != "shipa-keptn" {
+		eventLogger(myKeptn).Infof("Not handling get-sli event as it is meant for %s", data.GetSLI.SLIProvider)
+		return nil
 	}
 
-	_, err = myKeptn.SendTaskFinishedEvent(getSliFinishedEventData, ServiceName)
-
+	handler, err := NewShipaHandler(myKeptn)
 	if err != nil {
-		errMsg := fmt.Sprintf("Failed to send task finished CloudEvent (%s), aborting...", err.Error())
-		log.Println(errMsg)
-		return err
+		myKeptn.SendTaskStartedEvent(data, ServiceName)
+		return sendTaskErrored(myKeptn, err)
 	}
 
-	return nil
+	return handler.getSLI(ctx, myKeptn, data)
 }
 
 // HandleProblemEvent handles two problem events:
 // - ProblemOpenEventType = "sh.keptn.event.problem.open"
 // - ProblemEventType = "sh.keptn.events.problem"
 // TODO: add in your handler code
-func HandleProblemEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptn.ProblemEventData) error {
-	log.Printf("Handling Problem Event: %s", incomingEvent.Context.GetID())
+func HandleProblemEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptn.ProblemEventData) error {
+	eventLogger(myKeptn).Info("Handling Problem Event")
 
 	// Deprecated since Keptn 0.7.0 - use the HandleActionTriggeredEvent instead
 
@@ -192,12 +249,10 @@ func HandleProblemEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event,
 
 // HandleActionTriggeredEvent handles action.triggered events
 // TODO: add in your handler code
-func HandleActionTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ActionTriggeredEventData) error {
-	log.Printf("Handling Action Triggered Event: %s", incomingEvent.Context.GetID())
-	log.Printf("Action=%s\n", data.Action.Action)
-	log.Println("Value", data.Action.Value)
+func HandleActionTriggeredEvent(ctx context.Context, myKeptn *keptnv2.Keptn, incomingEvent cloudevents.Event, data *keptnv2.ActionTriggeredEventData) error {
+	eventLogger(myKeptn).Infow("Handling Action Triggered Event", "value", data.Action.Value)
 
-	handler, err := NewShipaHandler()
+	handler, err := NewShipaHandler(myKeptn)
 	if err != nil {
 		return err
 	}
@@ -205,68 +260,101 @@ func HandleActionTriggeredEvent(myKeptn *keptnv2.Keptn, incomingEvent cloudevent
 	// check if action is supported
 	switch data.Action.Action {
 	case "create.framework":
-		return handler.action(myKeptn, data, handler.createFramework)
+		return handler.action(ctx, myKeptn, data, handler.createFramework)
 	case "update.framework":
-		return handler.action(myKeptn, data, handler.updateFramework)
+		return handler.action(ctx, myKeptn, data, handler.updateFramework)
 	case "create.cluster":
-		return handler.action(myKeptn, data, handler.createCluster)
+		return handler.action(ctx, myKeptn, data, handler.createCluster)
 	case "update.cluster":
-		return handler.action(myKeptn, data, handler.updateCluster)
+		return handler.action(ctx, myKeptn, data, handler.updateCluster)
 	case "remove.cluster":
-		return handler.action(myKeptn, data, handler.removeCluster)
+		return handler.action(ctx, myKeptn, data, handler.removeCluster)
 	case "create.application":
-		return handler.action(myKeptn, data, handler.createApp)
+		return handler.action(ctx, myKeptn, data, handler.createApp)
 	case "deploy.application":
-		return handler.action(myKeptn, data, handler.deployApp)
+		return handler.action(ctx, myKeptn, data, func(ctx context.Context, rawData []byte) error {
+			return handler.deployApp(ctx, rawData, statusChangedReporter(myKeptn))
+		})
 
 	default:
-		log.Printf("Retrieved unknown action %s, skipping...", data.Action.Action)
+		eventLogger(myKeptn).Infof("Retrieved unknown action %s, skipping...", data.Action.Action)
+		// unknown action names are not used as label values
+		actionOutcomes.WithLabelValues("unknown", actionSkipped).Inc()
 	}
 
 	return nil
 }
 
+// sendTaskErrored sends a .finished event with status errored for the incoming .triggered event and returns err
+func sendTaskErrored(myKeptn *keptnv2.Keptn, err error) error {
+	myKeptn.SendTaskFinishedEvent(&keptnv2.EventData{
+		Status:  keptnv2.StatusErrored,
+		Result:  keptnv2.ResultFailed,
+		Message: err.Error(),
+	}, ServiceName)
+	return err
+}
+
+// ensureEventLabels makes sure the incoming event has a labels map. go-utils merges the labels of outgoing events
+// into it and panics if the incoming event has none.
+func ensureEventLabels(myKeptn *keptnv2.Keptn) {
+	if myKeptn.Event.GetLabels() == nil {
+		myKeptn.Event.SetLabels(make(map[string]string))
+	}
+}
+
 type ShipaHandler struct {
 	client *shipa.Client
+	target ShipaTarget
+	log    *zap.SugaredLogger
 }
 
-const (
-	ShipaHost  = "https://target.shipa.cloud"
-	ShipaToken = "..."
-)
+// NewShipaHandler returns a ShipaHandler with a client for the Shipa target of the event's project and stage
+func NewShipaHandler(myKeptn *keptnv2.Keptn) (*ShipaHandler, error) {
+	target, err := resolveShipaTarget(myKeptn)
+	if err != nil {
+		eventLogger(myKeptn).Errorw("failed to resolve shipa target", "error", err)
+		return nil, err
+	}
 
-func NewShipaHandler() (*ShipaHandler, error) {
-	client, err := shipa.NewClient(ShipaHost, ShipaToken)
+	client, err := shipaClients.get(target.ShipaCredentials)
 	if err != nil {
-		log.Println("ERR: failed to create shipa client:", err)
+		eventLogger(myKeptn).Errorw("failed to create shipa client", "error", err)
 		return nil, err
 	}
 
 	return &ShipaHandler{
 		client: client,
+		target: *target,
+		log:    eventLogger(myKeptn),
 	}, nil
 }
 
-func (s *ShipaHandler) action(myKeptn *keptnv2.Keptn, data *keptnv2.ActionTriggeredEventData, actionFn func(ctx context.Context, data []byte) error) error {
-	log.Println("1. Send Action.Started Cloud-Event")
+func (s *ShipaHandler) action(ctx context.Context, myKeptn *keptnv2.Keptn, data *keptnv2.ActionTriggeredEventData, actionFn func(ctx context.Context, data []byte) error) error {
+	ctx = shipa.ContextWithLogger(ctx, s.log)
+
+	s.log.Info("1. Send Action.Started Cloud-Event")
 	// -----------------------------------------------------
 	// 1. Send Action.Started Cloud-Event
 	// -----------------------------------------------------
 	myKeptn.SendTaskStartedEvent(data, ServiceName)
 
-	log.Println("2. Implement your remediation action here")
+	s.log.Info("2. Implement your remediation action here")
 	// -----------------------------------------------------
 	// 2. Implement your remediation action here
 	// -----------------------------------------------------
 
 	rawData, err := json.Marshal(data.Action.Value)
 	if err != nil {
-		log.Println("ERR: failed to marshal framework:", err)
+		s.log.Errorw("failed to marshal framework", "error", err)
 		return err
 	}
 
-	err = actionFn(context.Background(), rawData)
+	ctx, span := tracer.Start(ctx, "action "+data.Action.Action)
+	err = actionFn(ctx, rawData)
+	endSpan(span, err)
 	if err != nil {
+		actionOutcomes.WithLabelValues(data.Action.Action, actionErrored).Inc()
 		myKeptn.SendTaskFinishedEvent(&keptnv2.EventData{
 			Status:  keptnv2.StatusErrored, // alternative: keptnv2.StatusErrored
 			Result:  keptnv2.ResultFailed,  // alternative: keptnv2.ResultFailed
@@ -275,7 +363,9 @@ func (s *ShipaHandler) action(myKeptn *keptnv2.Keptn, data *keptnv2.ActionTrigge
 		return err
 	}
 
-	log.Println("3. Send Action.Finished Cloud-Event")
+	actionOutcomes.WithLabelValues(data.Action.Action, actionSucceeded).Inc()
+
+	s.log.Info("3. Send Action.Finished Cloud-Event")
 	// -----------------------------------------------------
 	// 3. Send Action.Finished Cloud-Event
 	// -----------------------------------------------------
@@ -292,13 +382,17 @@ func (s *ShipaHandler) createFramework(ctx context.Context, data []byte) error {
 	framework := &shipa.PoolConfig{}
 	err := json.Unmarshal(data, framework)
 	if err != nil {
-		log.Println("ERR: failed to unmarshal framework:", err)
+		s.log.Errorw("failed to unmarshal framework", "error", err)
 		return err
 	}
 
 	err = s.client.CreatePoolConfig(ctx, framework)
+	if shipa.IsConflict(err) {
+		s.log.Infof("Framework %s already exists, skipping creation", framework.Name)
+		return nil
+	}
 	if err != nil {
-		log.Println("ERR: failed to create framework:", err)
+		s.log.Errorw("failed to create framework", "error", err)
 		return err
 	}
 
@@ -309,13 +403,13 @@ func (s *ShipaHandler) updateFramework(ctx context.Context, data []byte) error {
 	framework := &shipa.PoolConfig{}
 	err := json.Unmarshal(data, framework)
 	if err != nil {
-		log.Println("ERR: failed to unmarshal framework:", err)
+		s.log.Errorw("failed to unmarshal framework", "error", err)
 		return err
 	}
 
 	err = s.client.UpdatePoolConfig(ctx, framework)
 	if err != nil {
-		log.Println("ERR: failed to update framework:", err)
+		s.log.Errorw("failed to update framework", "error", err)
 		return err
 	}
 
@@ -326,13 +420,17 @@ func (s *ShipaHandler) createCluster(ctx context.Context, data []byte) error {
 	cluster := &shipa.Cluster{}
 	err := json.Unmarshal(data, cluster)
 	if err != nil {
-		log.Println("ERR: failed to unmarshal cluster:", err)
+		s.log.Errorw("failed to unmarshal cluster", "error", err)
 		return err
 	}
 
 	err = s.client.CreateCluster(ctx, cluster)
+	if shipa.IsConflict(err) {
+		s.log.Infof("Cluster %s already exists, skipping creation", cluster.Name)
+		return nil
+	}
 	if err != nil {
-		log.Println("ERR: failed to create cluster:", err)
+		s.log.Errorw("failed to create cluster", "error", err)
 		return err
 	}
 
@@ -343,13 +441,13 @@ func (s *ShipaHandler) updateCluster(ctx context.Context, data []byte) error {
 	cluster := &shipa.Cluster{}
 	err := json.Unmarshal(data, cluster)
 	if err != nil {
-		log.Println("ERR: failed to unmarshal cluster:", err)
+		s.log.Errorw("failed to unmarshal cluster", "error", err)
 		return err
 	}
 
 	err = s.client.UpdateCluster(ctx, cluster)
 	if err != nil {
-		log.Println("ERR: failed to update cluster:", err)
+		s.log.Errorw("failed to update cluster", "error", err)
 		return err
 	}
 
@@ -360,13 +458,17 @@ func (s *ShipaHandler) removeCluster(ctx context.Context, data []byte) error {
 	cluster := &shipa.Cluster{}
 	err := json.Unmarshal(data, cluster)
 	if err != nil {
-		log.Println("ERR: failed to unmarshal cluster:", err)
+		s.log.Errorw("failed to unmarshal cluster", "error", err)
 		return err
 	}
 
 	err = s.client.DeleteCluster(ctx, cluster.Name)
+	if shipa.IsNotFound(err) {
+		s.log.Infof("Cluster %s does not exist, nothing to remove", cluster.Name)
+		return nil
+	}
 	if err != nil {
-		log.Println("ERR: failed to delete cluster:", err)
+		s.log.Errorw("failed to delete cluster", "error", err)
 		return err
 	}
 
@@ -377,13 +479,18 @@ func (s *ShipaHandler) createApp(ctx context.Context, data []byte) error {
 	app := &shipa.App{}
 	err := json.Unmarshal(data, app)
 	if err != nil {
-		log.Println("ERR: failed to unmarshal app:", err)
+		s.log.Errorw("failed to unmarshal app", "error", err)
 		return err
 	}
 
+	s.target.applyDefaults(app)
 	err = s.client.CreateApp(ctx, app)
+	if shipa.IsConflict(err) {
+		s.log.Infof("App %s already exists, skipping creation", app.Name)
+		return nil
+	}
 	if err != nil {
-		log.Println("ERR: failed to create app:", err)
+		s.log.Errorw("failed to create app", "error", err)
 		return err
 	}
 
@@ -395,19 +502,58 @@ type AppDeployConfig struct {
 	Deploy *shipa.AppDeploy `json:"deploy"`
 }
 
-func (s *ShipaHandler) deployApp(ctx context.Context, data []byte) error {
+func (s *ShipaHandler) deployApp(ctx context.Context, data []byte, progress progressFunc) error {
 	app := &AppDeployConfig{}
 	err := json.Unmarshal(data, app)
 	if err != nil {
-		log.Println("ERR: failed to unmarshal app deploy config:", err)
+		s.log.Errorw("failed to unmarshal app deploy config", "error", err)
 		return err
 	}
 
-	err = s.client.DeployApp(ctx, app.Name, app.Deploy)
-	if err != nil {
-		log.Println("ERR: failed to deploy app:", err)
+	_, err = s.deploy(ctx, app, progress, nil)
+	return err
+}
+
+// deploy deploys the app and waits until Shipa reports the new deployment as completed or deploymentTimeout expires.
+// If deployLog is set, it receives the deploy log streamed by Shipa.
+func (s *ShipaHandler) deploy(ctx context.Context, app *AppDeployConfig, progress progressFunc, deployLog *logTail) (*shipa.AppDeployment, error) {
+	return s.rollout(ctx, app.Name, progress, func(ctx context.Context) error {
+		err := s.client.DeployAppWithLogs(ctx, app.Name, app.Deploy, deployLog.add)
+		if err != nil {
+			s.log.Errorw("failed to deploy app", "error", err)
+		}
 		return err
+	})
+}
+
+// rollout calls start to create a new deployment of the app and waits until Shipa reports it as completed
+// or deploymentTimeout expires
+func (s *ShipaHandler) rollout(ctx context.Context, appName string, progress progressFunc, start func(ctx context.Context) error) (deployment *shipa.AppDeployment, err error) {
+	ctx, span := tracer.Start(ctx, "rollout", trace.WithAttributes(attribute.String("shipa.app", appName)))
+	defer func() { endSpan(span, err) }()
+
+	ctx, cancel := context.WithTimeout(ctx, deploymentTimeout)
+	defer cancel()
+
+	deploymentsInFlight.Inc()
+	defer deploymentsInFlight.Dec()
+
+	known, err := s.deploymentIDs(ctx, appName)
+	if err != nil {
+		return nil, err
 	}
+	journalRollout(ctx, appName, known)
 
-	return nil
+	err = start(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	deployment, err = s.waitForDeployment(ctx, appName, known, progress)
+	if err != nil {
+		s.log.Errorw("failed to wait for app deployment", "error", err)
+		return nil, err
+	}
+
+	return deployment, nil
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Paths of the liveness and readiness endpoints, next to the CloudEvents receiver
+const (
+	healthPath = "/health"
+	readyPath  = "/ready"
+)
+
+// readinessTimeout limits each readiness check
+const readinessTimeout = 5 * time.Second
+
+// readinessCheck checks a dependency of the service. It returns checkSkipped if the dependency isn't configured.
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// checkSkipped is returned by checks that don't apply to the configuration of the service
+type checkSkipped string
+
+func (s checkSkipped) Error() string {
+	return string(s)
+}
+
+// readinessChecks returns the checks of /ready: the default Shipa credentials authenticate and the Keptn API
+// is reachable
+func readinessChecks(env envConfig) []readinessCheck {
+	return []readinessCheck{
+		{name: "shipa", check: checkShipaAuthentication},
+		{name: "keptn", check: keptnAPICheck(env.ConfigurationServiceUrl, http.DefaultClient)},
+	}
+}
+
+// checkShipaAuthentication checks within ctx that the default Shipa credentials authenticate, by pinging the
+// pooled client or a new one in place of a failing client.
+func checkShipaAuthentication(ctx context.Context) error {
+	credentials, err := shipaCredentialsFor(nil)
+	if err != nil {
+		return err
+	}
+	if credentials.Host == "" || credentials.Token == "" {
+		return checkSkipped("no default Shipa credentials, targets are configured per project")
+	}
+
+	return shipaClients.check(ctx, *credentials)
+}
+
+// keptnAPICheck returns a check that the Keptn configuration service answers. Any response but a server error
+// counts, as the check has no API token.
+func keptnAPICheck(configurationServiceURL string, client *http.Client) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if keptnOptions.UseLocalFileSystem || configurationServiceURL == "" {
+			return checkSkipped("resources are read from the local filesystem")
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(configurationServiceURL, "/")+"/v1/project?pageSize=1", nil)
+		if err != nil {
+			return err
+		}
+		res, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+
+		if res.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("configuration service returned status %d", res.StatusCode)
+		}
+		return nil
+	}
+}
+
+// readiness is the response of /ready
+type readiness struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks"`
+}
+
+// ready runs the checks and returns their results
+func ready(ctx context.Context, checks []readinessCheck) readiness {
+	result := readiness{Ready: true, Checks: make(map[string]string, len(checks))}
+	for _, c := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, readinessTimeout)
+		err := c.check(checkCtx)
+		cancel()
+
+		switch err.(type) {
+		case nil:
+			result.Checks[c.name] = "ok"
+		case checkSkipped:
+			result.Checks[c.name] = "skipped: " + err.Error()
+		default:
+			result.Ready = false
+			result.Checks[c.name] = err.Error()
+		}
+	}
+	return result
+}
+
+// withHealth serves /health, which succeeds as long as the service serves requests, and /ready, which fails
+// unless all checks succeed. All other requests are passed to next.
+func withHealth(checks []readinessCheck) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case healthPath:
+				w.Write([]byte("OK"))
+			case readyPath:
+				result := ready(r.Context(), checks)
+				if !result.Ready {
+					logger.Warnw("readiness check failed", "checks", result.Checks)
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				if !result.Ready {
+					w.WriteHeader(http.StatusServiceUnavailable)
+				}
+				json.NewEncoder(w).Encode(result)
+			default:
+				next.ServeHTTP(w, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Tests that /ready reports the results of the readiness checks and fails unless all succeed
+func TestHealthEndpoints(t *testing.T) {
+	shipaErr := errors.New("shipa client auth failed")
+	checks := []readinessCheck{
+		{name: "shipa", check: func(ctx context.Context) error { return shipaErr }},
+		{name: "keptn", check: func(ctx context.Context) error { return checkSkipped("local") }},
+	}
+	server := httptest.NewServer(withHealth(checks)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + healthPath)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("Expected /health to succeed, but got %v %v", res, err)
+	}
+
+	res, err = http.Get(server.URL + readyPath)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	result := readiness{}
+	json.NewDecoder(res.Body).Decode(&result)
+	res.Body.Close()
+	if res.StatusCode != http.StatusServiceUnavailable || result.Ready {
+		t.Errorf("Expected /ready to fail, but got %d", res.StatusCode)
+	}
+	if result.Checks["shipa"] != shipaErr.Error() || result.Checks["keptn"] != "skipped: local" {
+		t.Errorf("Expected the results of the checks, but got %v", result.Checks)
+	}
+
+	checks[0].check = func(ctx context.Context) error { return nil }
+	res, err = http.Get(server.URL + readyPath)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("Expected /ready to succeed, but got %v %v", res, err)
+	}
+
+	res, err = http.Get(server.URL + "/")
+	if err != nil || res.StatusCode != http.StatusTeapot {
+		t.Errorf("Expected other requests to reach the CloudEvents receiver, but got %v %v", res, err)
+	}
+}
+
+// Tests that the Keptn API counts as reachable unless the configuration service fails
+func TestKeptnAPICheck(t *testing.T) {
+	status := http.StatusUnauthorized
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	check := keptnAPICheck(server.URL+"/configuration-service", server.Client())
+	if err := check(context.Background()); err != nil {
+		t.Errorf("Expected the configuration service to be reachable, but got %v", err)
+	}
+
+	status = http.StatusBadGateway
+	if err := check(context.Background()); err == nil {
+		t.Errorf("Expected an error for a failing configuration service")
+	}
+
+	if _, ok := keptnAPICheck("", server.Client())(context.Background()).(checkSkipped); !ok {
+		t.Errorf("Expected the check to be skipped without configuration service")
+	}
+}
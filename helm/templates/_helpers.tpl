@@ -61,3 +61,17 @@ Create the name of the service account to use
 {{- default "default" .Values.serviceAccount.name }}
 {{- end }}
 {{- end }}
+
+{{/*
+Create the name of the secret holding the Shipa credentials
+*/}}
+{{- define "keptn-service.shipaSecretName" -}}
+{{- default (printf "%s-shipa" (include "keptn-service.fullname" .)) .Values.shipa.existingSecret }}
+{{- end }}
+
+{{/*
+Create the name of the secret holding the Shipa TLS CA bundle and client certificate
+*/}}
+{{- define "keptn-service.shipaTLSSecretName" -}}
+{{- default (printf "%s-shipa-tls" (include "keptn-service.fullname" .)) .Values.shipa.tls.existingSecret }}
+{{- end }}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
+	bolt "go.etcd.io/bbolt"
+)
+
+// journalOpenTimeout is how long opening the journal waits for the previous instance to release its lock
+const journalOpenTimeout = 30 * time.Second
+
+// journalBucket is the bucket of the journal entries, keyed by dedupKey
+var journalBucket = []byte("tasks")
+
+// journal records the tasks in flight, _main opens it from envConfig
+var journal = &taskJournal{}
+
+// journalEntry is a Keptn task that was started but not finished
+type journalEntry struct {
+	// Event is the .triggered event of the task
+	Event   cloudevents.Event `json:"event"`
+	Started time.Time         `json:"started"`
+	// App, Known and DeploymentID describe the last Shipa rollout started for the task: the app, the IDs of its
+	// deployments before the rollout, and the ID of the new deployment once Shipa reports it
+	App            string    `json:"app,omitempty"`
+	Known          []string  `json:"known,omitempty"`
+	DeploymentID   string    `json:"deploymentID,omitempty"`
+	RolloutStarted time.Time `json:"rolloutStarted,omitempty"`
+
+	key string
+}
+
+// taskJournal keeps the tasks in flight in an embedded database on disk, so that the tasks left unfinished by
+// an instance are resumed by the next one. Without a database it records nothing.
+type taskJournal struct {
+	db *bolt.DB
+}
+
+// openTaskJournal opens the journal database in file, or returns a journal recording nothing if file is empty
+func openTaskJournal(file string) (*taskJournal, error) {
+	if file == "" {
+		return &taskJournal{}, nil
+	}
+
+	db, err := bolt.Open(file, 0600, &bolt.Options{Timeout: journalOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(journalBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &taskJournal{db: db}, nil
+}
+
+func (j *taskJournal) close() error {
+	if j.db == nil {
+		return nil
+	}
+	return j.db.Close()
+}
+
+// entries returns the tasks in the journal, oldest first
+func (j *taskJournal) entries() ([]*journalEntry, error) {
+	entries := make([]*journalEntry, 0)
+	if j.db == nil {
+		return entries, nil
+	}
+
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(key, value []byte) error {
+			entry := &journalEntry{}
+			if err := json.Unmarshal(value, entry); err != nil {
+				logger.Errorw("failed to decode task journal entry, skipping it", "key", string(key), "error", err)
+				return nil
+			}
+			entry.key = string(key)
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, k int) bool { return entries[i].Started.Before(entries[k].Started) })
+	return entries, nil
+}
+
+// get returns the entry of a task, or nil if the task isn't in the journal
+func (j *taskJournal) get(key string) *journalEntry {
+	if j.db == nil {
+		return nil
+	}
+
+	var entry *journalEntry
+	err := j.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(journalBucket).Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		entry = &journalEntry{key: key}
+		return json.Unmarshal(value, entry)
+	})
+	if err != nil {
+		logger.Errorw("failed to read task journal", "key", key, "error", err)
+		return nil
+	}
+	return entry
+}
+
+// put records a task
+func (j *taskJournal) put(key string, entry *journalEntry) {
+	j.write(key, func(tx *bolt.Tx) error {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(journalBucket).Put([]byte(key), value)
+	})
+}
+
+// update changes the entry of a task with fn, if the task is in the journal. The entry is only written back
+// if fn returns true.
+func (j *taskJournal) update(key string, fn func(entry *journalEntry) bool) {
+	j.write(key, func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(journalBucket)
+		value := bucket.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+		entry := &journalEntry{}
+		if err := json.Unmarshal(value, entry); err != nil {
+			return err
+		}
+		if !fn(entry) {
+			return nil
+		}
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), value)
+	})
+}
+
+// remove removes a finished task
+func (j *taskJournal) remove(key string) {
+	j.write(key, func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).Delete([]byte(key))
+	})
+}
+
+func (j *taskJournal) write(key string, fn func(tx *bolt.Tx) error) {
+	if j.db == nil {
+		return
+	}
+	if err := j.db.Update(fn); err != nil {
+		logger.Errorw("failed to write task journal", "key", key, "error", err)
+	}
+}
+
+// resumable reports whether a task has started a Shipa rollout, which the next instance waits for if the task
+// is left unfinished
+func (j *taskJournal) resumable(key string) bool {
+	entry := j.get(key)
+	return entry != nil && entry.App != ""
+}
+
+// journalSender records the task of the event with the key in the journal when it sends the .started event,
+// and removes it once it has sent the .finished event
+type journalSender struct {
+	key       string
+	journal   *taskJournal
+	triggered cloudevents.Event
+	sender    keptn.EventSender
+}
+
+// SendEvent sends the event and records the task it starts or finishes
+func (s *journalSender) SendEvent(event cloudevents.Event) error {
+	// the task is recorded before Keptn learns it has started, so that it can't be left unfinished unnoticed
+	started := strings.HasSuffix(event.Type(), ".started") && s.journal.get(s.key) == nil
+	if started {
+		s.journal.put(s.key, &journalEntry{Event: s.triggered, Started: time.Now()})
+	}
+
+	err := s.sender.SendEvent(event)
+	switch {
+	case err != nil && started:
+		s.journal.remove(s.key)
+	case err == nil && strings.HasSuffix(event.Type(), ".finished"):
+		s.journal.remove(s.key)
+	}
+	return err
+}
+
+// resumable reports whether the task is resumed by the next instance if it is abandoned
+func (s *journalSender) resumable() bool {
+	return s.journal.resumable(s.key)
+}
+
+type journalKey struct{}
+
+// contextWithJournalKey returns a context of the task with the key, whose rollouts are recorded in the journal
+func contextWithJournalKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, journalKey{}, key)
+}
+
+// journalRollout records a rollout of an app started by the task of ctx, known are the IDs of its deployments
+// before the rollout
+func journalRollout(ctx context.Context, appName string, known map[string]bool) {
+	key, ok := ctx.Value(journalKey{}).(string)
+	if !ok {
+		return
+	}
+
+	ids := make([]string, 0, len(known))
+	for id := range known {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	journal.update(key, func(entry *journalEntry) bool {
+		entry.App = appName
+		entry.Known = ids
+		entry.DeploymentID = ""
+		entry.RolloutStarted = time.Now()
+		return true
+	})
+}
+
+// journalDeployment records the ID of the deployment the rollout of the task of ctx waits for
+func journalDeployment(ctx context.Context, id string) {
+	key, ok := ctx.Value(journalKey{}).(string)
+	if !ok {
+		return
+	}
+
+	journal.update(key, func(entry *journalEntry) bool {
+		if entry.DeploymentID == id {
+			return false
+		}
+		entry.DeploymentID = id
+		return true
+	})
+}
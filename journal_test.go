@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
+)
+
+// Tests that started tasks and their rollouts are journaled across restarts, and that a resumed task is finished
+// with the outcome of the journaled deployment
+func TestTaskJournal(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "journal.db")
+	var err error
+	journal, err = openTaskJournal(file)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer func() { journal = &taskJournal{} }()
+
+	myKeptn, _, err := initializeTestObjects("test-events/deployment.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	key := dedupKey(myKeptn)
+	myKeptn.EventSender = &journalSender{key: key, journal: journal, triggered: *myKeptn.CloudEvent, sender: myKeptn.EventSender}
+	ensureEventLabels(myKeptn)
+
+	myKeptn.SendTaskStartedEvent(&keptnv2.EventData{}, ServiceName)
+	ctx := contextWithJournalKey(context.Background(), key)
+	journalRollout(ctx, "sockshop-dev-carts", map[string]bool{"1": true})
+	journalDeployment(ctx, "2")
+
+	// a restart finds the task and its rollout in the journal
+	journal.close()
+	journal, err = openTaskJournal(file)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	entries, err := journal.entries()
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected one task in the journal, but got %d", len(entries))
+	}
+	entry := entries[0]
+	if entry.Event.ID() != myKeptn.CloudEvent.ID() || entry.App != "sockshop-dev-carts" || entry.DeploymentID != "2" ||
+		len(entry.Known) != 1 || entry.Known[0] != "1" {
+		t.Errorf("Unexpected journal entry: %+v", entry)
+	}
+	if !journal.resumable(key) {
+		t.Errorf("Expected the task to be resumable")
+	}
+
+	// the journaled deployment is waited for, not the one started after it
+	deploymentPollInterval = time.Millisecond
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-dev-carts/deployments", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]*shipa.AppDeployment{
+			{ID: "3", Version: "3", Error: "later deployment failed"},
+			{ID: "2", Version: "2", Active: true},
+			{ID: "1", Version: "1"},
+		})
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&shipa.App{
+			Name:  "sockshop-dev-carts",
+			IP:    "carts.shipa.cloud",
+			Units: []*shipa.Unit{{ID: "u1", Version: "2", Status: "started"}},
+		})
+	})
+	handler := newFakeShipaHandler(t, mux)
+
+	resumedKeptn, _, err := initializeTestObjects("test-events/deployment.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	sender := resumedKeptn.EventSender.(*fake.EventSender)
+	resumedKeptn.EventSender = &journalSender{key: key, journal: journal, triggered: entry.Event, sender: sender}
+	ensureEventLabels(resumedKeptn)
+
+	if err := handler.resume(context.Background(), resumedKeptn, entry); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	finished := sender.SentEvents[len(sender.SentEvents)-1]
+	if finished.Type() != keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName) {
+		t.Fatalf("Expected a deployment.finished event, but got %s", finished.Type())
+	}
+	finishedData := &keptnv2.DeploymentFinishedEventData{}
+	if err := finished.DataAs(finishedData); err != nil {
+		t.Fatalf("Error getting deployment.finished event data: %s", err)
+	}
+	if finishedData.Status != keptnv2.StatusSucceeded || len(finishedData.Deployment.DeploymentURIsPublic) != 1 {
+		t.Errorf("Unexpected deployment.finished event data: %+v", finishedData)
+	}
+
+	if entries, _ := journal.entries(); len(entries) != 0 {
+		t.Errorf("Expected the finished task to be removed from the journal, but got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logger is the service logger, set from envConfig at startup
+var logger = zap.NewNop().Sugar()
+
+// newLogger returns a logger writing JSON lines to stderr for the given level and above
+func newLogger(level string) (*zap.SugaredLogger, error) {
+	config := zap.NewProductionConfig()
+	if err := config.Level.UnmarshalText([]byte(strings.ToLower(level))); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	// every line may be needed to follow a sequence
+	config.Sampling = nil
+	config.EncoderConfig.TimeKey = "time"
+	config.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	l, err := config.Build()
+	if err != nil {
+		return nil, err
+	}
+	return l.Sugar(), nil
+}
+
+// eventLogger returns a logger that adds the Keptn context, event ID and type, project, stage and service
+// of the incoming event to every line, and the action name for action events
+func eventLogger(myKeptn *keptnv2.Keptn) *zap.SugaredLogger {
+	if myKeptn == nil || myKeptn.CloudEvent == nil {
+		return logger
+	}
+
+	event := myKeptn.CloudEvent
+	fields := []interface{}{
+		"keptn_context", myKeptn.KeptnContext,
+		"event_id", event.ID(),
+		"event_type", event.Type(),
+	}
+	if myKeptn.Event != nil {
+		fields = append(fields,
+			"project", myKeptn.Event.GetProject(),
+			"stage", myKeptn.Event.GetStage(),
+			"service", myKeptn.Event.GetService(),
+		)
+	}
+
+	if event.Type() == keptnv2.GetTriggeredEventType(keptnv2.ActionTaskName) {
+		data := &keptnv2.ActionTriggeredEventData{}
+		if err := event.DataAs(data); err == nil {
+			fields = append(fields, "action", data.Action.Action)
+		}
+	}
+
+	return logger.With(fields...)
+}
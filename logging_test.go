@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// Tests that handler and Shipa client log lines carry the fields of the event
+func TestEventLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	defer func(l *zap.SugaredLogger) { logger = l }(logger)
+	logger = zap.New(core).Sugar()
+
+	myKeptn, _, err := initializeTestObjects("test-events/action.triggered.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps/sockshop-dev-carts", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("{}"))
+	})
+	handler := newFakeShipaHandler(t, mux)
+	handler.log = eventLogger(myKeptn)
+
+	handler.log.Info("handling event")
+	if _, err := handler.client.GetApp(shipa.ContextWithLogger(context.Background(), handler.log), "sockshop-dev-carts"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	expected := map[string]string{
+		"keptn_context": "08735340-6f9e-4b32-97ff-3b6c292bc50i",
+		"event_id":      "f2b878d3-03c0-4e8f-bc3f-454bc1b3d79b",
+		"project":       "sockshop",
+		"stage":         "dev",
+		"service":       "carts",
+		"action":        "action-xyz",
+	}
+	if logs.Len() != 2 {
+		t.Fatalf("Expected a handler and a client log line, but got %d", logs.Len())
+	}
+	for _, entry := range logs.All() {
+		fields := entry.ContextMap()
+		for key, value := range expected {
+			if fields[key] != value {
+				t.Errorf("Expected %s=%s in %q, but got %v", key, value, entry.Message, fields[key])
+			}
+		}
+	}
+}
@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"errors"
-	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/brunoa19/shipa-keptn/shipa"
 	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
 	"github.com/kelseyhightower/envconfig"
-	keptnlib "github.com/keptn/go-utils/pkg/lib"
 	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
 	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var keptnOptions = keptn.KeptnOpts{}
@@ -23,466 +27,169 @@ type envConfig struct {
 	Path string `envconfig:"RCV_PATH" default:"/"`
 	// Whether we are running locally (e.g., for testing) or on production
 	Env string `envconfig:"ENV" default:"local"`
+	// Lowest level of the JSON log lines, one of debug, info, warn and error
+	LogLevel string `envconfig:"LOG_LEVEL" default:"info"`
 	// URL of the Keptn configuration service (this is where we can fetch files from the config repo)
 	ConfigurationServiceUrl string `envconfig:"CONFIGURATION_SERVICE" default:""`
+	// How long to wait for a Shipa deployment to complete before reporting it as failed
+	DeploymentTimeout time.Duration `envconfig:"DEPLOYMENT_TIMEOUT" default:"15m"`
+	// Default Shipa host and token, used unless overridden by the credentials secret or the config repo
+	ShipaHost  string `envconfig:"SHIPA_HOST" default:""`
+	ShipaToken string `envconfig:"SHIPA_TOKEN" default:""`
+	// Directory the Kubernetes secret with the Shipa host and token is mounted at
+	ShipaCredentialsDir string `envconfig:"SHIPA_CREDENTIALS_DIR" default:""`
+	// Link to the log of an app in the Shipa dashboard, {app} is replaced with the app name
+	ShipaAppLogURL string `envconfig:"SHIPA_APP_LOG_URL" default:""`
+	// How often the pooled Shipa clients are checked, failing ones are re-authenticated on their next use
+	ShipaHealthCheckInterval time.Duration `envconfig:"SHIPA_HEALTH_CHECK_INTERVAL" default:"1m"`
+	// TLS configuration of the connections to Shipa, PEM encoded values are given directly or as files
+	ShipaCA                 string `envconfig:"SHIPA_CA" default:""`
+	ShipaCAFile             string `envconfig:"SHIPA_CA_FILE" default:""`
+	ShipaClientCert         string `envconfig:"SHIPA_CLIENT_CERT" default:""`
+	ShipaClientCertFile     string `envconfig:"SHIPA_CLIENT_CERT_FILE" default:""`
+	ShipaClientKey          string `envconfig:"SHIPA_CLIENT_KEY" default:""`
+	ShipaClientKeyFile      string `envconfig:"SHIPA_CLIENT_KEY_FILE" default:""`
+	ShipaInsecureSkipVerify bool   `envconfig:"SHIPA_INSECURE_SKIP_VERIFY" default:"false"`
+	// How many events are handled at a time, events of the same project, stage and service one after another
+	WorkerConcurrency int `envconfig:"WORKER_CONCURRENCY" default:"4"`
+	// How many received events may wait for a worker before new ones are rejected
+	WorkerQueueSize int `envconfig:"WORKER_QUEUE_SIZE" default:"100"`
+	// How many recent events are remembered to detect redeliveries, and the file they are persisted to, if any
+	DedupCapacity int    `envconfig:"DEDUP_CAPACITY" default:"1000"`
+	DedupFile     string `envconfig:"DEDUP_FILE" default:""`
+	// File of the journal of the tasks in flight, which are resumed after a restart. Tasks aren't journaled if empty.
+	JournalFile string `envconfig:"JOURNAL_FILE" default:""`
+	// How long a shutdown waits for the events in flight before abandoning their tasks
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"45s"`
+	// OTLP endpoint spans are exported to, tracing is disabled if empty. The exporter reads the other
+	// OTEL_EXPORTER_OTLP_* env vars itself.
+	OTLPEndpoint string `envconfig:"OTEL_EXPORTER_OTLP_ENDPOINT" default:""`
 }
 
 // ServiceName specifies the current services name (e.g., used as source when sending CloudEvents)
 const ServiceName = "shipa-keptn"
 
 /**
- * Parses a Keptn Cloud Event payload (data attribute)
+ * This method gets called when a new event is received from the Keptn Event Distributor
+ * It acknowledges the event right away and queues it to the worker pool, which calls handleKeptnCloudEvent.
+ * Events of the same project, stage and service are handled one after another.
  */
-func parseKeptnCloudEventPayload(event cloudevents.Event, data interface{}) error {
-	err := event.DataAs(data)
+func processKeptnCloudEvent(ctx context.Context, event cloudevents.Event) (err error) {
+	eventsReceived.WithLabelValues(eventTypeLabel(event.Type())).Inc()
+
+	ctx, span := startEventSpan(ctx, event)
+	defer func() {
+		if err != nil {
+			endSpan(span, err)
+		}
+	}()
+
+	myKeptn, err := newEventKeptn(ctx, span, event)
+	var malformed *payloadError
+	if errors.As(err, &malformed) {
+		// events go-utils can't handle aren't queued, their tasks are finished right away
+		rejectKeptn, err := malformedEventKeptn(ctx, event)
+		if err != nil {
+			logger.Errorw("failed to reject malformed event", "event_id", event.ID(), "event_type", event.Type(), "error", err)
+			eventsMalformed.WithLabelValues(eventTypeLabel(malformed.EventType), malformed.Reason).Inc()
+		} else {
+			rejectMalformedEvent(rejectKeptn, malformed, eventHandlers.lookup(event.Type()).finishesTask(nil))
+		}
+		endSpan(span, malformed)
+		return nil
+	}
 	if err != nil {
-		log.Fatalf("Got Data Error: %s", err.Error())
 		return err
 	}
-	return nil
+
+	// redelivered events aren't handled again
+	key := dedupKey(myKeptn)
+	if outcome, isNew := deduplicator.begin(key); !isNew {
+		err = handleDuplicate(myKeptn, outcome)
+		if err == nil {
+			span.End()
+		}
+		return err
+	}
+
+	return queueTask(ctx, span, myKeptn, key, func(ctx context.Context) error {
+		observed := observeHandler(event.Type())
+		err := handleKeptnCloudEvent(ctx, myKeptn, event)
+		observed(err)
+		return err
+	})
 }
 
-/**
- * This method gets called when a new event is received from the Keptn Event Distributor
- * Depending on the Event Type will call the specific event handler functions, e.g: handleDeploymentFinishedEvent
- * See https://github.com/keptn/spec/blob/0.2.0-alpha/cloudevents.md for details on the payload
- */
-func processKeptnCloudEvent(ctx context.Context, event cloudevents.Event) error {
-	// create keptn handler
-	log.Printf("Initializing Keptn Handler")
+// newEventKeptn returns the Keptn handler of an event, whose outgoing events continue the trace of span
+func newEventKeptn(ctx context.Context, span trace.Span, event cloudevents.Event) (*keptnv2.Keptn, error) {
+	logger.Debugw("Initializing Keptn Handler", "event_id", event.ID(), "event_type", event.Type())
+	if err := validateEventEnvelope(event); err != nil {
+		return nil, err
+	}
 	myKeptn, err := keptnv2.NewKeptn(&event, keptnOptions)
 	if err != nil {
-		return errors.New("Could not create Keptn Handler: " + err.Error())
+		return nil, errors.New("Could not create Keptn Handler: " + err.Error())
 	}
+	// outgoing events continue the trace of the incoming one
+	myKeptn.EventSender = &tracingEventSender{ctx: ctx, sender: myKeptn.EventSender}
+	span.SetAttributes(
+		attribute.String("keptn.context", myKeptn.KeptnContext),
+		attribute.String("keptn.project", myKeptn.Event.GetProject()),
+		attribute.String("keptn.stage", myKeptn.Event.GetStage()),
+		attribute.String("keptn.service", myKeptn.Event.GetService()),
+	)
+	return myKeptn, nil
+}
 
-	log.Printf("gotEvent(%s): %s - %s", event.Type(), myKeptn.KeptnContext, event.Context.GetID())
+// queueTask tracks the event with the deduplication key, records its task in the journal and queues handle to
+// the workers, which ends span once the event is handled
+func queueTask(ctx context.Context, span trace.Span, myKeptn *keptnv2.Keptn, key string, handle func(ctx context.Context) error) error {
+	myKeptn.EventSender = &dedupSender{key: key, store: deduplicator, sender: myKeptn.EventSender}
+	myKeptn.EventSender = &journalSender{key: key, journal: journal, triggered: *myKeptn.CloudEvent, sender: myKeptn.EventSender}
 
+	// the task is tracked from now on, so that a shutdown waits for it while it is queued
+	ctx, done, err := tasks.start(contextWithJournalKey(ctx, key), myKeptn)
 	if err != nil {
-		log.Printf("failed to parse incoming cloudevent: %v", err)
+		deduplicator.forget(key)
 		return err
 	}
 
-	/**
-	* CloudEvents types in Keptn 0.8.0 follow the following pattern:
-	* - sh.keptn.event.${EVENTNAME}.triggered
-	* - sh.keptn.event.${EVENTNAME}.started
-	* - sh.keptn.event.${EVENTNAME}.status.changed
-	* - sh.keptn.event.${EVENTNAME}.finished
-	*
-	* For convenience, types can be generated using the following methods:
-	* - triggered:      keptnv2.GetTriggeredEventType(${EVENTNAME}) (e.g,. keptnv2.GetTriggeredEventType(keptnv2.DeploymentTaskName))
-	* - started:        keptnv2.GetStartedEventType(${EVENTNAME}) (e.g,. keptnv2.GetStartedEventType(keptnv2.DeploymentTaskName))
-	* - status.changed: keptnv2.GetStatusChangedEventType(${EVENTNAME}) (e.g,. keptnv2.GetStatusChangedEventType(keptnv2.DeploymentTaskName))
-	* - finished:       keptnv2.GetFinishedEventType(${EVENTNAME}) (e.g,. keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName))
-	*
-	* Keptn reserves some Cloud Event types, please read up on that here: https://keptn.sh/docs/0.8.x/manage/shipyard/
-	*
-	* For those Cloud Events the keptn/go-utils library conveniently provides several data structures
-	* and strings in github.com/keptn/go-utils/pkg/lib/v0_2_0, e.g.:
-	* - deployment: DeploymentTaskName, DeploymentTriggeredEventData, DeploymentStartedEventData, DeploymentFinishedEventData
-	* - test: TestTaskName, TestTriggeredEventData, TestStartedEventData, TestFinishedEventData
-	* - ... (they all follow the same pattern)
-	*
-	*
-	* In most cases you will be interested in processing .triggered events (e.g., sh.keptn.event.deployment.triggered),
-	* which you an achieve as follows:
-	* if event.type() == keptnv2.GetTriggeredEventType(keptnv2.DeploymentTaskName) { ... }
-	*
-	* Processing the event payload can be achieved as follows:
-	*
-	* eventData := &keptnv2.DeploymentTriggeredEventData{}
-	* parseKeptnCloudEventPayload(event, eventData)
-	*
-	* See https://github.com/keptn/spec/blob/0.2.0-alpha/cloudevents.md for more details of Keptn Cloud Events and their payload
-	* Also, see https://github.com/keptn-sandbox/echo-service/blob/a90207bc119c0aca18368985c7bb80dea47309e9/pkg/events.go as an example how to create your own CloudEvents
-	**/
-
-	/**
-	* The following code presents a very generic implementation of processing almost all possible
-	* Cloud Events that are retrieved by this service.
-	* Please follow the documentation provided above for more guidance on the different types.
-	* Feel free to delete parts that you don't need.
-	**/
-	switch event.Type() {
-
-	// -------------------------------------------------------
-	// sh.keptn.event.project.create - Note: This is due to change
-	case keptnv2.GetStartedEventType(keptnv2.ProjectCreateTaskName): // sh.keptn.event.project.create.started
-		log.Printf("Processing Project.Create.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ProjectCreateStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.ProjectCreateTaskName): // sh.keptn.event.project.create.finished
-		log.Printf("Processing Project.Create.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ProjectCreateFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	// -------------------------------------------------------
-	// sh.keptn.event.service.create - Note: This is due to change
-	case keptnv2.GetStartedEventType(keptnv2.ServiceCreateTaskName): // sh.keptn.event.service.create.started
-		log.Printf("Processing Service.Create.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ServiceCreateStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.ServiceCreateTaskName): // sh.keptn.event.service.create.finished
-		log.Printf("Processing Service.Create.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ServiceCreateFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.approval
-	case keptnv2.GetTriggeredEventType(keptnv2.ApprovalTaskName): // sh.keptn.event.approval.triggered
-		log.Printf("Processing Approval.Triggered Event")
-
-		eventData := &keptnv2.ApprovalTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleApprovalTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.ApprovalTaskName): // sh.keptn.event.approval.started
-		log.Printf("Processing Approval.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ApprovalStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.ApprovalTaskName): // sh.keptn.event.approval.finished
-		log.Printf("Processing Approval.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ApprovalFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.deployment
-	case keptnv2.GetTriggeredEventType(keptnv2.DeploymentTaskName): // sh.keptn.event.deployment.triggered
-		log.Printf("Processing Deployment.Triggered Event")
-
-		eventData := &keptnv2.DeploymentTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleDeploymentTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.DeploymentTaskName): // sh.keptn.event.deployment.started
-		log.Printf("Processing Deployment.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.DeploymentStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName): // sh.keptn.event.deployment.finished
-		log.Printf("Processing Deployment.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.DeploymentFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.test
-	case keptnv2.GetTriggeredEventType(keptnv2.TestTaskName): // sh.keptn.event.test.triggered
-		log.Printf("Processing Test.Triggered Event")
-
-		eventData := &keptnv2.TestTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleTestTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.TestTaskName): // sh.keptn.event.test.started
-		log.Printf("Processing Test.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.TestStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.TestTaskName): // sh.keptn.event.test.finished
-		log.Printf("Processing Test.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.TestFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.evaluation
-	case keptnv2.GetTriggeredEventType(keptnv2.EvaluationTaskName): // sh.keptn.event.evaluation.triggered
-		log.Printf("Processing Evaluation.Triggered Event")
-
-		eventData := &keptnv2.EvaluationTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleEvaluationTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.EvaluationTaskName): // sh.keptn.event.evaluation.started
-		log.Printf("Processing Evaluation.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.EvaluationStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.EvaluationTaskName): // sh.keptn.event.evaluation.finished
-		log.Printf("Processing Evaluation.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.EvaluationFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.release
-	case keptnv2.GetTriggeredEventType(keptnv2.ReleaseTaskName): // sh.keptn.event.release.triggered
-		log.Printf("Processing Release.Triggered Event")
-
-		eventData := &keptnv2.ReleaseTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleReleaseTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.ReleaseTaskName): // sh.keptn.event.release.started
-		log.Printf("Processing Release.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ReleaseStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetStatusChangedEventType(keptnv2.ReleaseTaskName): // sh.keptn.event.release.status.changed
-		log.Printf("Processing Release.Status.Changed Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ReleaseStatusChangedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.ReleaseTaskName): // sh.keptn.event.release.finished
-		log.Printf("Processing Release.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ReleaseFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.get-action (sent by shipyard-controller to extract a remediation action from remediations.yaml)
-	case keptnv2.GetTriggeredEventType(keptnv2.GetActionTaskName): // sh.keptn.event.action.triggered
-		log.Printf("Processing Get-Action.Triggered Event")
-
-		eventData := &keptnv2.GetActionTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.GetActionTaskName): // sh.keptn.event.action.started
-		log.Printf("Processing Get-Action.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.GetActionStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.GetActionTaskName): // sh.keptn.event.action.finished
-		log.Printf("Processing Get-Action.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.GetActionFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.action (sent by shipyard-controller to execute a remediation action)
-	case keptnv2.GetTriggeredEventType(keptnv2.ActionTaskName): // sh.keptn.event.action.triggered
-		log.Printf("Processing Action.Triggered Event")
-
-		eventData := &keptnv2.ActionTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleActionTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.ActionTaskName): // sh.keptn.event.action.started
-		log.Printf("Processing Action.Started Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ActionStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.ActionTaskName): // sh.keptn.event.action.finished
-		log.Printf("Processing Action.Finished Event")
-		// Please note: Processing .started, .status.changed and .finished events is only recommended when you want to
-		// notify an external service (e.g., for logging purposes).
-
-		eventData := &keptnv2.ActionFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.problem / problem.open
-	// Please Note: This is deprecated; Use Action.Triggered instead
-	case keptnlib.ProblemEventType: // sh.keptn.event.problem - e.g., sent by Dynatrace to Keptn Api
-		log.Printf("Processing problem Event")
-		log.Printf("Subscribing to a problem.open or problem event is not recommended since Keptn 0.7. Please subscribe to event of type: sh.keptn.event.action.triggered")
-
-		eventData := &keptnlib.ProblemEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleProblemEvent(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.get-sli (sent by lighthouse-service to fetch SLIs from the sli provider)
-	case keptnv2.GetTriggeredEventType(keptnv2.GetSLITaskName): // sh.keptn.event.get-sli.triggered
-		log.Printf("Processing Get-SLI.Triggered Event")
-
-		eventData := &keptnv2.GetSLITriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleGetSliTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.GetSLITaskName): // sh.keptn.event.get-sli.started
-		log.Printf("Processing Get-SLI.Started Event")
-
-		eventData := &keptnv2.GetSLIStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.GetSLITaskName): // sh.keptn.event.get-sli.finished
-		log.Printf("Processing Get-SLI.Finished Event")
-
-		eventData := &keptnv2.GetSLIFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// sh.keptn.event.configure-monitoring
-	case keptnlib.ConfigureMonitoringEventType: // old configure-monitoring CE; compatibility with 0.8.0-alpha
-		log.Printf("Processing old configure-monitoring Event")
-		log.Printf("Note: This will be deprecated with Keptn 0.8.0")
-
-		eventData := &keptnlib.ConfigureMonitoringEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Handle old configure-monitoring event
-		return OldHandleConfigureMonitoringEvent(myKeptn, event, eventData)
-
-	case keptnv2.GetTriggeredEventType(keptnv2.ConfigureMonitoringTaskName): // sh.keptn.event.configure-monitoring.triggered
-		log.Printf("Processing configure-monitoring.Triggered Event")
-
-		eventData := &keptnv2.ConfigureMonitoringTriggeredEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		return HandleConfigureMonitoringTriggeredEvent(myKeptn, event, eventData)
-	case keptnv2.GetStartedEventType(keptnv2.ConfigureMonitoringTaskName): // sh.keptn.event.configure-monitoring.started
-		log.Printf("Processing configure-monitoring.Started Event")
-
-		eventData := &keptnv2.ConfigureMonitoringStartedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-	case keptnv2.GetFinishedEventType(keptnv2.ConfigureMonitoringTaskName): // sh.keptn.event.configure-monitoring.finished
-		log.Printf("Processing configure-monitoring.Finished Event")
-
-		eventData := &keptnv2.ConfigureMonitoringFinishedEventData{}
-		parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-	// -------------------------------------------------------
-	// your custom cloud event, e.g., sh.keptn.your-event
-	// see https://github.com/keptn-sandbox/echo-service/blob/a90207bc119c0aca18368985c7bb80dea47309e9/pkg/events.go
-	// for an example on how to generate your own CloudEvents and structs
-	case keptnv2.GetTriggeredEventType("your-event"): // sh.keptn.event.your-event.triggered
-		log.Printf("Processing your-event.triggered Event")
-
-		// eventData := &keptnv2.YourEventTriggeredEventData{}
-		//  parseKeptnCloudEventPayload(event, eventData)
-
-		break
-	case keptnv2.GetStartedEventType(keptnv2.ConfigureMonitoringTaskName): // sh.keptn.event.your-event.started
-		log.Printf("Processing your-event.started Event")
-
-		// eventData := &keptnv2.YourEventStartedEventData{}
-		// parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		// return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-		break
-	case keptnv2.GetFinishedEventType(keptnv2.ConfigureMonitoringTaskName): // sh.keptn.event.your-event.finished
-		log.Printf("Processing your-event.finished Event")
-
-		// eventData := &keptnv2.YourEventFinishedEventData{}
-		// parseKeptnCloudEventPayload(event, eventData)
-
-		// Just log this event
-		// return GenericLogKeptnCloudEventHandler(myKeptn, event, eventData)
-
-		break
+	err = eventWorkers.submit(eventKey(myKeptn), func() {
+		defer done()
+		if ctx.Err() != nil {
+			// the drain has already finished the task of an owned event
+			eventLogger(myKeptn).Warn("Dropping event abandoned while queued")
+			deduplicator.forget(key)
+			endSpan(span, ctx.Err())
+			return
+		}
+
+		err := handle(ctx)
+		deduplicator.complete(key, err)
+		endSpan(span, err)
+	})
+	if err != nil {
+		done()
+		deduplicator.forget(key)
+		eventLogger(myKeptn).Warnw("Rejecting event", "error", err)
+		return err
 	}
+	return nil
+}
 
-	// Unknown Event -> Throw Error!
-	var errorMsg string
-	errorMsg = fmt.Sprintf("Unhandled Keptn Cloud Event: %s", event.Type())
+// eventKey returns the key serializing the handling of the event, its project, stage and service
+func eventKey(myKeptn *keptnv2.Keptn) string {
+	project, stage, service := myKeptn.Event.GetProject(), myKeptn.Event.GetStage(), myKeptn.Event.GetService()
+	if project == "" && stage == "" && service == "" {
+		return ""
+	}
+	return project + "/" + stage + "/" + service
+}
 
-	log.Print(errorMsg)
-	return errors.New(errorMsg)
+// handleKeptnCloudEvent passes the event to the handler registered for its type
+func handleKeptnCloudEvent(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event) error {
+	eventLogger(myKeptn).Info("gotEvent")
+	return eventHandlers.handle(ctx, myKeptn, event)
 }
 
 /**
@@ -505,35 +212,93 @@ func main() {
  * Opens up a listener on localhost:port/path and passes incoming requets to gotEvent
  */
 func _main(args []string, env envConfig) int {
+	l, err := newLogger(env.LogLevel)
+	if err != nil {
+		log.Fatalf("failed to create logger, %v", err)
+	}
+	defer l.Sync()
+	logger = l
+
 	// configure keptn options
 	if env.Env == "local" {
-		log.Println("env=local: Running with local filesystem to fetch resources")
+		logger.Info("env=local: Running with local filesystem to fetch resources")
 		keptnOptions.UseLocalFileSystem = true
 	}
 
 	keptnOptions.ConfigurationServiceURL = env.ConfigurationServiceUrl
+	deploymentTimeout = env.DeploymentTimeout
+	shipaCredentials = ShipaCredentials{Host: env.ShipaHost, Token: env.ShipaToken}
+	shipaCredentialsDir = env.ShipaCredentialsDir
+	appLogURL = env.ShipaAppLogURL
+	eventWorkers = newWorkerPool(env.WorkerConcurrency, env.WorkerQueueSize)
+	deduplicator = newDedupStore(env.DedupCapacity, env.DedupFile)
+	if err := deduplicator.load(); err != nil {
+		logger.Warnw("failed to load deduplication store, starting without the outcomes of earlier events", "file", env.DedupFile, "error", err)
+	}
 
-	log.Println("Starting shipa-keptn...")
-	log.Printf("    on Port = %d; Path=%s", env.Port, env.Path)
+	tlsOptions, err := shipaTLSOptions(env)
+	if err != nil {
+		logger.Fatalf("failed to configure Shipa TLS, %v", err)
+	}
+	shipaClients.options = append(shipaClients.options, tlsOptions...)
+	shipaClients.options = append(shipaClients.options, shipa.WithLogger(logger))
 
-	ctx := context.Background()
+	logger.Info("Starting shipa-keptn...")
+	eventHandlers.logRegistrations()
+	logger.Infof("    on Port = %d; Path=%s; Metrics=%s; Health=%s; Ready=%s", env.Port, env.Path, metricsPath, healthPath, readyPath)
+
+	// SIGTERM stops the receiver and drains the events in flight
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
 	ctx = cloudevents.WithEncodingStructured(ctx)
 
-	log.Printf("Creating new http handler")
+	shutdownTracing, err := setupTracing(ctx, env)
+	if err != nil {
+		logger.Fatalf("failed to set up tracing, %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	go shipaClients.runHealthChecks(ctx, env.ShipaHealthCheckInterval)
+
+	// the tasks left unfinished by the previous instance are queued ahead of the events received
+	journal, err = openTaskJournal(env.JournalFile)
+	if err != nil {
+		logger.Fatalf("failed to open task journal %s, %v", env.JournalFile, err)
+	}
+	defer journal.close()
+	resumeTasks(ctx)
+
+	logger.Info("Creating new http handler")
 
 	// configure http server to receive cloudevents
-	p, err := cloudevents.NewHTTP(cloudevents.WithPath(env.Path), cloudevents.WithPort(env.Port))
+	p, err := cloudevents.NewHTTP(cloudevents.WithPath(env.Path), cloudevents.WithPort(env.Port),
+		cloudevents.WithMiddleware(withMetrics), cloudevents.WithMiddleware(withHealth(readinessChecks(env))),
+		// the server waits for the drain, which abandons the remaining events after ShutdownTimeout
+		cloudevents.WithShutdownTimeout(env.ShutdownTimeout+2*abandonGracePeriod))
 
 	if err != nil {
-		log.Fatalf("failed to create client, %v", err)
+		logger.Fatalf("failed to create client, %v", err)
 	}
 	c, err := cloudevents.NewClient(p)
 	if err != nil {
-		log.Fatalf("failed to create client, %v", err)
+		logger.Fatalf("failed to create client, %v", err)
 	}
 
-	log.Printf("Starting receiver")
-	log.Fatal(c.StartReceiver(ctx, processKeptnCloudEvent))
+	drained := make(chan struct{})
+	go func() {
+		<-ctx.Done()
+		logger.Info("Shutting down, no longer accepting events")
+		tasks.drain(env.ShutdownTimeout)
+		close(drained)
+	}()
+
+	logger.Info("Starting receiver")
+	if err := c.StartReceiver(ctx, processKeptnCloudEvent); err != nil {
+		logger.Errorf("receiver failed, %v", err)
+		return 1
+	}
 
+	<-drained
+	logger.Info("Shut down")
 	return 0
 }
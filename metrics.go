@@ -0,0 +1,132 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsPath is the path the Prometheus metrics are served at, next to the CloudEvents receiver
+const metricsPath = "/metrics"
+
+const metricsNamespace = "shipa_keptn"
+
+// otherEventType is the type label of the events without a registered handler, which would otherwise add a
+// label value for every event type sent by Keptn
+const otherEventType = "other"
+
+var (
+	eventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_received_total",
+		Help:      "Keptn CloudEvents received, by event type (other for types without a handler).",
+	}, []string{"type"})
+
+	eventHandlerDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "event_handler_duration_seconds",
+		Help:      "Time spent handling Keptn CloudEvents, by event type (other for types without a handler) and result.",
+		// deployments wait for the rollout to complete
+		Buckets: []float64{0.1, 0.5, 1, 5, 15, 30, 60, 120, 300, 600, 900},
+	}, []string{"type", "result"})
+
+	eventsDeduplicated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_deduplicated_total",
+		Help:      "Redelivered Keptn CloudEvents that weren't handled again, by whether their .finished event was replayed or they were dropped.",
+	}, []string{"outcome"})
+
+	tasksResumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tasks_resumed_total",
+		Help:      "Keptn tasks left unfinished by a previous instance and finished from the journal, by outcome.",
+	}, []string{"outcome"})
+
+	eventsMalformed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "events_malformed_total",
+		Help:      "Keptn CloudEvents rejected because they are malformed, by event type and reason.",
+	}, []string{"type", "reason"})
+
+	actionOutcomes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "action_outcomes_total",
+		Help:      "Remediation actions handled, by action name and outcome.",
+	}, []string{"action", "outcome"})
+
+	shipaRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "shipa_requests_total",
+		Help:      "Requests sent to the Shipa API, by method, endpoint and status code. The code is 0 if no response was received.",
+	}, []string{"method", "endpoint", "code"})
+
+	shipaRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "shipa_request_duration_seconds",
+		Help:      "Latency of the requests sent to the Shipa API until the response headers arrived, by method, endpoint and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "endpoint", "code"})
+
+	deploymentsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "deployments_in_flight",
+		Help:      "Shipa deployments, rollbacks and releases currently waited for.",
+	})
+)
+
+// Outcomes of action_outcomes_total
+const (
+	actionSucceeded = "succeeded"
+	actionErrored   = "errored"
+	actionSkipped   = "skipped"
+)
+
+// Outcomes of tasks_resumed_total
+const (
+	taskResumeSucceeded = "succeeded"
+	taskResumeErrored   = "errored"
+)
+
+// eventTypeLabel returns the type label of an event, otherEventType if no handler is registered for its type
+func eventTypeLabel(eventType string) string {
+	if eventHandlers.lookup(eventType) == nil {
+		return otherEventType
+	}
+	return eventType
+}
+
+// observeHandler returns a function that records the handler duration of an event with the error it returned
+func observeHandler(eventType string) func(err error) {
+	start := time.Now()
+
+	return func(err error) {
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		eventHandlerDuration.WithLabelValues(eventTypeLabel(eventType), result).Observe(time.Since(start).Seconds())
+	}
+}
+
+// observeShipaRequest records a request attempt of the Shipa clients, it is their shipa.RequestObserver
+func observeShipaRequest(method, endpoint string, statusCode int, latency time.Duration) {
+	code := strconv.Itoa(statusCode)
+	shipaRequests.WithLabelValues(method, endpoint, code).Inc()
+	shipaRequestDuration.WithLabelValues(method, endpoint, code).Observe(latency.Seconds())
+}
+
+// withMetrics serves the Prometheus metrics at metricsPath and passes all other requests to next
+func withMetrics(next http.Handler) http.Handler {
+	metrics := promhttp.Handler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == metricsPath {
+			metrics.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
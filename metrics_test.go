@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Tests that the metrics are served next to the CloudEvents receiver
+func TestMetrics(t *testing.T) {
+	received := false
+	server := httptest.NewServer(withMetrics(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = true
+	})))
+	defer server.Close()
+
+	eventsReceived.WithLabelValues("sh.keptn.event.deployment.triggered").Inc()
+	observeHandler("sh.keptn.event.deployment.triggered")(errors.New("failed"))
+	observeShipaRequest(http.MethodGet, "apps/:name", http.StatusNotFound, time.Millisecond)
+
+	if count := testutil.ToFloat64(eventsReceived.WithLabelValues("sh.keptn.event.deployment.triggered")); count != 1 {
+		t.Errorf("Expected 1 event received, but got %v", count)
+	}
+	if label := eventTypeLabel("sh.keptn.event.unknown-task.triggered"); label != otherEventType {
+		t.Errorf("Expected event types without a handler to be labeled %s, but got %s", otherEventType, label)
+	}
+	if count := testutil.ToFloat64(shipaRequests.WithLabelValues(http.MethodGet, "apps/:name", "404")); count != 1 {
+		t.Errorf("Expected 1 Shipa request, but got %v", count)
+	}
+
+	res, err := http.Get(server.URL + metricsPath)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+
+	for _, metric := range []string{
+		`shipa_keptn_event_handler_duration_seconds_count{result="error",type="sh.keptn.event.deployment.triggered"} 1`,
+		`shipa_keptn_shipa_request_duration_seconds_count{code="404",endpoint="apps/:name",method="GET"} 1`,
+		"shipa_keptn_deployments_in_flight 0",
+	} {
+		if !strings.Contains(string(body), metric) {
+			t.Errorf("Expected %s in the metrics, but got %s", metric, body)
+		}
+	}
+	if received {
+		t.Errorf("Expected the metrics request not to reach the CloudEvents receiver")
+	}
+
+	if _, err := http.Post(server.URL+"/", "application/json", strings.NewReader("{}")); err != nil || !received {
+		t.Errorf("Expected other requests to reach the CloudEvents receiver")
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// keptnContextExtension is the CloudEvent extension with the Keptn context of an event
+const keptnContextExtension = "shkeptncontext"
+
+// Reasons of payloadError, the reason label of events_malformed_total
+const (
+	reasonMissingContext    = "missing_context"
+	reasonInvalidData       = "invalid_data"
+	reasonMissingProperties = "missing_properties"
+)
+
+// payloadError is returned for a Keptn CloudEvent that can't be handled because it is malformed
+type payloadError struct {
+	EventType string
+	EventID   string
+	Reason    string
+	Err       error
+}
+
+func (e *payloadError) Error() string {
+	return fmt.Sprintf("malformed %s event %s (%s): %s", e.EventType, e.EventID, e.Reason, e.Err)
+}
+
+func (e *payloadError) Unwrap() error {
+	return e.Err
+}
+
+func newPayloadError(event cloudevents.Event, reason string, err error) *payloadError {
+	return &payloadError{EventType: event.Type(), EventID: event.ID(), Reason: reason, Err: err}
+}
+
+// validateEventEnvelope checks that an event has a Keptn context and a payload with the Keptn event properties,
+// which go-utils requires of every event
+func validateEventEnvelope(event cloudevents.Event) error {
+	if keptnContext, _ := event.Extensions()[keptnContextExtension].(string); keptnContext == "" {
+		return newPayloadError(event, reasonMissingContext, errors.New("no "+keptnContextExtension+" extension"))
+	}
+	if err := event.DataAs(&keptnv2.EventData{}); err != nil {
+		return newPayloadError(event, reasonInvalidData, err)
+	}
+	return nil
+}
+
+// parseKeptnCloudEventPayload parses the payload (data attribute) of a Keptn CloudEvent into data. Payloads
+// that can't be parsed are returned as *payloadError.
+func parseKeptnCloudEventPayload(event cloudevents.Event, data interface{}) error {
+	if err := event.DataAs(data); err != nil {
+		return newPayloadError(event, reasonInvalidData, err)
+	}
+	return nil
+}
+
+// requireProperties returns a payloadValidator requiring the named Keptn event properties of a payload, any of
+// project, stage and service
+func requireProperties(properties ...string) payloadValidator {
+	return func(event cloudevents.Event, data interface{}) error {
+		eventProperties, ok := data.(keptn.EventProperties)
+		if !ok {
+			return nil
+		}
+		values := map[string]string{
+			"project": eventProperties.GetProject(),
+			"stage":   eventProperties.GetStage(),
+			"service": eventProperties.GetService(),
+		}
+		missing := make([]string, 0)
+		for _, property := range properties {
+			if values[property] == "" {
+				missing = append(missing, property)
+			}
+		}
+		if len(missing) > 0 {
+			return newPayloadError(event, reasonMissingProperties, fmt.Errorf("no %s", strings.Join(missing, ", ")))
+		}
+		return nil
+	}
+}
+
+// rejectMalformedEvent logs and counts a malformed event and returns its error. If this service implements the
+// task of the event, the task is started and finished with status errored, so that the sequence doesn't wait for it.
+func rejectMalformedEvent(myKeptn *keptnv2.Keptn, err *payloadError, finishTask bool) error {
+	log := eventLogger(myKeptn)
+	log.Warnw("Rejecting malformed event", "reason", err.Reason, "error", err.Err)
+	eventsMalformed.WithLabelValues(eventTypeLabel(err.EventType), err.Reason).Inc()
+
+	if !finishTask || myKeptn.KeptnContext == "" {
+		return err
+	}
+
+	ensureEventLabels(myKeptn)
+	if _, sendErr := myKeptn.SendTaskStartedEvent(&keptnv2.EventData{}, ServiceName); sendErr != nil {
+		log.Errorw("failed to start task of malformed event", "error", sendErr)
+		return err
+	}
+	_, sendErr := myKeptn.SendTaskFinishedEvent(&keptnv2.EventData{
+		Status:  keptnv2.StatusErrored,
+		Result:  keptnv2.ResultFailed,
+		Message: err.Error(),
+	}, ServiceName)
+	if sendErr != nil {
+		log.Errorw("failed to finish task of malformed event", "error", sendErr)
+	}
+	return err
+}
+
+// malformedEventKeptn returns a Keptn handler for an event go-utils can't handle, with its payload replaced by an
+// empty one. Its outgoing events continue the trace of ctx.
+func malformedEventKeptn(ctx context.Context, event cloudevents.Event) (*keptnv2.Keptn, error) {
+	clone := event.Clone()
+	keptnContext, _ := event.Extensions()[keptnContextExtension].(string)
+	clone.SetExtension(keptnContextExtension, keptnContext)
+	if err := clone.SetData(cloudevents.ApplicationJSON, &keptnv2.EventData{}); err != nil {
+		return nil, err
+	}
+
+	myKeptn, err := keptnv2.NewKeptn(&clone, keptnOptions)
+	if err != nil {
+		return nil, err
+	}
+	myKeptn.EventSender = &tracingEventSender{ctx: ctx, sender: myKeptn.EventSender}
+	return myKeptn, nil
+}
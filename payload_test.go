@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// Tests that malformed payloads are returned as structured errors and finish the tasks of this service as errored
+func TestMalformedEvents(t *testing.T) {
+	myKeptn, incomingEvent, err := initializeTestObjects("test-events/deployment.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if err := parseKeptnCloudEventPayload(*incomingEvent, &keptnv2.DeploymentTriggeredEventData{}); err != nil {
+		t.Fatalf("Expected a valid payload, but got %s", err)
+	}
+
+	tests := []struct {
+		data   string
+		reason string
+	}{
+		{data: `{"project": 5}`, reason: reasonInvalidData},
+		{data: `{"project": "sockshop"}`, reason: reasonMissingProperties},
+	}
+	for _, test := range tests {
+		event := incomingEvent.Clone()
+		event.SetData(cloudevents.ApplicationJSON, []byte(test.data))
+
+		var malformed *payloadError
+		data := &keptnv2.DeploymentTriggeredEventData{}
+		err := parseKeptnCloudEventPayload(event, data)
+		if err == nil {
+			err = requireProperties("project", "stage", "service")(event, data)
+		}
+		if !errors.As(err, &malformed) || malformed.Reason != test.reason || malformed.EventID != event.ID() {
+			t.Errorf("Expected a payload error with reason %s for %s, but got %v", test.reason, test.data, err)
+		}
+	}
+
+	// the registry starts and finishes the task of a malformed .triggered event as errored
+	sender := myKeptn.EventSender.(*fake.EventSender)
+	event := incomingEvent.Clone()
+	event.SetData(cloudevents.ApplicationJSON, []byte(`{"project": "sockshop", "stage": "dev"}`))
+	before := testutil.ToFloat64(eventsMalformed.WithLabelValues(event.Type(), reasonMissingProperties))
+	if err := eventHandlers.handle(context.Background(), myKeptn, event); err == nil {
+		t.Errorf("Expected an error for the malformed event")
+	}
+	if count := testutil.ToFloat64(eventsMalformed.WithLabelValues(event.Type(), reasonMissingProperties)); count != before+1 {
+		t.Errorf("Expected the malformed event to be counted")
+	}
+	if len(sender.SentEvents) != 2 || sender.SentEvents[0].Type() != keptnv2.GetStartedEventType(keptnv2.DeploymentTaskName) ||
+		sender.SentEvents[1].Type() != keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName) {
+		t.Fatalf("Expected a deployment.started and a deployment.finished event, but got %v", sender.SentEvents)
+	}
+	finished := &keptnv2.EventData{}
+	if err := sender.SentEvents[1].DataAs(finished); err != nil || finished.Status != keptnv2.StatusErrored {
+		t.Errorf("Expected a .finished event with status errored, but got %+v", finished)
+	}
+
+	// configure-monitoring.triggered events have no stage, and the tasks of get-action aren't this service's
+	monitoring := incomingEvent.Clone()
+	monitoring.SetType(keptnv2.GetTriggeredEventType(keptnv2.ConfigureMonitoringTaskName))
+	monitoring.SetData(cloudevents.ApplicationJSON, []byte(`{"project": "sockshop", "service": "carts", "type": "prometheus"}`))
+	if err := eventHandlers.handle(context.Background(), myKeptn, monitoring); err != nil {
+		t.Errorf("Expected the configure-monitoring.triggered event without stage to be handled, but got %s", err)
+	}
+	getAction := incomingEvent.Clone()
+	getAction.SetType(keptnv2.GetTriggeredEventType(keptnv2.GetActionTaskName))
+	getAction.SetData(cloudevents.ApplicationJSON, []byte(`{"project": ["sockshop"]}`))
+	if err := eventHandlers.handle(context.Background(), myKeptn, getAction); err == nil {
+		t.Errorf("Expected an error for the malformed get-action.triggered event")
+	}
+	if len(sender.SentEvents) != 2 {
+		t.Errorf("Expected no events for tasks of other services, but got %v", sender.SentEvents[2:])
+	}
+
+	// events go-utils can't handle are rejected without crashing the service
+	rejectSender := &fake.EventSender{}
+	options := keptnOptions
+	defer func() { keptnOptions = options }()
+	keptnOptions.EventSender = rejectSender
+	keptnOptions.UseLocalFileSystem = true
+
+	invalid := incomingEvent.Clone()
+	invalid.SetData(cloudevents.ApplicationJSON, []byte(`{"project": ["sockshop"]}`))
+	if err := processKeptnCloudEvent(context.Background(), invalid); err != nil {
+		t.Errorf("Expected the malformed event to be accepted, but got %s", err)
+	}
+	if len(rejectSender.SentEvents) != 2 || rejectSender.SentEvents[1].Type() != keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName) {
+		t.Errorf("Expected a deployment.started and a deployment.finished event for the event with invalid data, but got %v", rejectSender.SentEvents)
+	}
+
+	noContext := incomingEvent.Clone()
+	delete(noContext.Context.(*cloudevents.EventContextV1).Extensions, keptnContextExtension)
+	if err := processKeptnCloudEvent(context.Background(), noContext); err != nil {
+		t.Errorf("Expected the event without Keptn context to be accepted, but got %s", err)
+	}
+	if count := testutil.ToFloat64(eventsMalformed.WithLabelValues(noContext.Type(), reasonMissingContext)); count != 1 {
+		t.Errorf("Expected the event without Keptn context to be counted")
+	}
+}
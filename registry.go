@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+/**
+* Keptn CloudEvent types follow the pattern sh.keptn.event.${EVENTNAME}.(triggered|started|status.changed|finished),
+* keptnv2.GetTriggeredEventType(${EVENTNAME}) and friends build them. keptn/go-utils has the payload structs of the
+* Keptn tasks in github.com/keptn/go-utils/pkg/lib/v0_2_0, e.g. DeploymentTriggeredEventData.
+* See https://github.com/keptn/spec/blob/0.2.0-alpha/cloudevents.md for the payloads of the Keptn CloudEvents.
+*
+* Handlers are registered for an event type with a factory of its payload, see eventhandlers.go. Other files can
+* register the handlers of their own event types in an init function. Registrations of the .triggered events of the
+* tasks this service implements add ownsTask and a validator of the payload properties their handler needs.
+**/
+
+// eventHandlerFunc handles an event, data is the payload parsed into the value returned by the factory of its registration
+type eventHandlerFunc func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error
+
+// payloadValidator checks a parsed payload before it is handled and returns a *payloadError if it is malformed
+type payloadValidator func(event cloudevents.Event, data interface{}) error
+
+// eventRegistration is the handler of an event type and the factory of its payload
+type eventRegistration struct {
+	eventType string
+	newData   func() interface{}
+	handle    eventHandlerFunc
+	validate  payloadValidator
+	// owns reports whether this service implements the task of an event with the payload, which may be nil if
+	// the payload can't be parsed
+	owns func(data interface{}) bool
+}
+
+// registrationOption configures an eventRegistration
+type registrationOption func(r *eventRegistration)
+
+// withValidator checks the payloads of the event type with validate before they are handled
+func withValidator(validate payloadValidator) registrationOption {
+	return func(r *eventRegistration) {
+		r.validate = validate
+	}
+}
+
+// ownsTask marks the event type as the .triggered event of a task this service implements, which is finished
+// as errored if the payload of the event is malformed. If owns is given, the task is only this service's for
+// the payloads owns returns true for.
+func ownsTask(owns ...func(data interface{}) bool) registrationOption {
+	return func(r *eventRegistration) {
+		r.owns = func(data interface{}) bool { return true }
+		if len(owns) > 0 {
+			r.owns = owns[0]
+		}
+	}
+}
+
+// finishesTask reports whether this service finishes the task of an event of the registration with the payload
+func (r *eventRegistration) finishesTask(data interface{}) bool {
+	return r != nil && r.owns != nil && r.owns(data)
+}
+
+// payloadType returns the Go type the payload is parsed into
+func (r *eventRegistration) payloadType() string {
+	return fmt.Sprintf("%T", r.newData())
+}
+
+// eventRegistry maps event types to their handlers. Events of unregistered types go to its default handler.
+type eventRegistry struct {
+	mu            sync.RWMutex
+	registrations map[string]*eventRegistration
+	fallback      func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event) error
+}
+
+// eventHandlers has the handlers of the event types this service handles, _main logs them at startup
+var eventHandlers = newEventRegistry()
+
+func newEventRegistry() *eventRegistry {
+	return &eventRegistry{
+		registrations: make(map[string]*eventRegistration),
+		fallback:      ignoreEvent,
+	}
+}
+
+// register registers handle for events of eventType, whose payloads are parsed into the values returned by newData.
+// Registering an event type twice is a programming error and panics.
+func (r *eventRegistry) register(eventType string, newData func() interface{}, handle eventHandlerFunc, options ...registrationOption) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.registrations[eventType]; ok {
+		panic(fmt.Sprintf("event handler for %s registered twice", eventType))
+	}
+	registration := &eventRegistration{eventType: eventType, newData: newData, handle: handle}
+	for _, option := range options {
+		option(registration)
+	}
+	r.registrations[eventType] = registration
+}
+
+// setDefault replaces the handler of events of unregistered types
+func (r *eventRegistry) setDefault(fallback func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.fallback = fallback
+}
+
+// lookup returns the registration of an event type, or nil if it has none
+func (r *eventRegistry) lookup(eventType string) *eventRegistration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.registrations[eventType]
+}
+
+// ownsEvent reports whether this service finishes the task of the event, judging by its payload if it can be parsed
+func (r *eventRegistry) ownsEvent(event cloudevents.Event) bool {
+	registration := r.lookup(event.Type())
+	if registration == nil || registration.owns == nil {
+		return false
+	}
+	data := registration.newData()
+	if err := parseKeptnCloudEventPayload(event, data); err != nil {
+		return registration.finishesTask(nil)
+	}
+	return registration.finishesTask(data)
+}
+
+// list returns the registrations ordered by event type
+func (r *eventRegistry) list() []*eventRegistration {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	registrations := make([]*eventRegistration, 0, len(r.registrations))
+	for _, registration := range r.registrations {
+		registrations = append(registrations, registration)
+	}
+	sort.Slice(registrations, func(i, k int) bool { return registrations[i].eventType < registrations[k].eventType })
+	return registrations
+}
+
+// handle parses the payload of the event and passes it to the handler registered for its type
+func (r *eventRegistry) handle(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event) error {
+	registration := r.lookup(event.Type())
+	if registration == nil {
+		r.mu.RLock()
+		fallback := r.fallback
+		r.mu.RUnlock()
+		return fallback(ctx, myKeptn, event)
+	}
+
+	eventLogger(myKeptn).Infof("Processing %s Event", event.Type())
+	data := registration.newData()
+	err := parseKeptnCloudEventPayload(event, data)
+	if err == nil && registration.validate != nil {
+		err = registration.validate(event, data)
+	}
+	if err != nil {
+		var malformed *payloadError
+		if errors.As(err, &malformed) {
+			return rejectMalformedEvent(myKeptn, malformed, registration.finishesTask(data))
+		}
+		return err
+	}
+	return registration.handle(ctx, myKeptn, event, data)
+}
+
+// logRegistrations logs the registered event types and their payload types
+func (r *eventRegistry) logRegistrations() {
+	registrations := r.list()
+	types := make(map[string]string, len(registrations))
+	for _, registration := range registrations {
+		types[registration.eventType] = registration.payloadType()
+	}
+	logger.Infow("Registered event handlers", "handlers", len(registrations), "types", types)
+}
+
+// ignoreEvent is the default handler of events of unregistered types, which this service doesn't handle
+func ignoreEvent(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event) error {
+	eventLogger(myKeptn).Infof("Ignoring %s Event, no handler is registered for it", event.Type())
+	return nil
+}
+
+// logEvent is the handler of events that are only logged
+func logEvent(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+	return GenericLogKeptnCloudEventHandler(myKeptn, event, data)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// Tests that events are passed to the handler of their type with their typed payload, and unknown ones to the default
+func TestEventRegistry(t *testing.T) {
+	myKeptn, incomingEvent, err := initializeTestObjects("test-events/deployment.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	registry := newEventRegistry()
+	var handled *keptnv2.DeploymentTriggeredEventData
+	registry.register(incomingEvent.Type(),
+		func() interface{} { return &keptnv2.DeploymentTriggeredEventData{} },
+		func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event, data interface{}) error {
+			handled = data.(*keptnv2.DeploymentTriggeredEventData)
+			return nil
+		})
+
+	if err := registry.handle(context.Background(), myKeptn, *incomingEvent); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if handled == nil || handled.Project != "sockshop" {
+		t.Errorf("Expected the handler to get the parsed payload, but got %+v", handled)
+	}
+
+	// unknown event types are ignored unless another default is set
+	unknown := incomingEvent.Clone()
+	unknown.SetType(keptnv2.GetTriggeredEventType("your-event"))
+	if err := registry.handle(context.Background(), myKeptn, unknown); err != nil {
+		t.Errorf("Expected events of unknown types to be ignored, but got %s", err)
+	}
+	var fallback string
+	registry.setDefault(func(ctx context.Context, myKeptn *keptnv2.Keptn, event cloudevents.Event) error {
+		fallback = event.Type()
+		return nil
+	})
+	registry.handle(context.Background(), myKeptn, unknown)
+	if fallback != unknown.Type() {
+		t.Errorf("Expected the default handler to get the %s event, but got %q", unknown.Type(), fallback)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expected registering an event type twice to panic")
+			}
+		}()
+		registry.register(incomingEvent.Type(), func() interface{} { return &keptnv2.EventData{} }, logEvent)
+	}()
+
+	registrations := eventHandlers.list()
+	for i := 1; i < len(registrations); i++ {
+		if registrations[i-1].eventType >= registrations[i].eventType {
+			t.Fatalf("Expected the registrations to be ordered by event type")
+		}
+	}
+	deployment := eventHandlers.lookup(keptnv2.GetTriggeredEventType(keptnv2.DeploymentTaskName))
+	if deployment == nil || deployment.payloadType() != "*v0_2_0.DeploymentTriggeredEventData" {
+		t.Errorf("Expected a handler of deployment.triggered events with a DeploymentTriggeredEventData payload")
+	}
+
+	// only the tasks this service implements are finished for malformed events
+	if !deployment.finishesTask(nil) {
+		t.Errorf("Expected the deployment task to be finished by this service")
+	}
+	if eventHandlers.lookup(keptnv2.GetTriggeredEventType(keptnv2.GetActionTaskName)).finishesTask(nil) {
+		t.Errorf("Expected the get-action task not to be finished by this service")
+	}
+	getSLI := eventHandlers.lookup(keptnv2.GetTriggeredEventType(keptnv2.GetSLITaskName))
+	otherProvider := &keptnv2.GetSLITriggeredEventData{GetSLI: keptnv2.GetSLI{SLIProvider: "prometheus"}}
+	if getSLI.finishesTask(otherProvider) || getSLI.validate(unknown, otherProvider) != nil {
+		t.Errorf("Expected get-sli events of other SLI providers to be left to them")
+	}
+}
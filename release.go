@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// activeDeployment returns the active deployment of an app
+func activeDeployment(deployments []*shipa.AppDeployment) (*shipa.AppDeployment, error) {
+	for _, deployment := range deployments {
+		if deployment.Active {
+			return deployment, nil
+		}
+	}
+	return nil, errors.New("app has no active deployment")
+}
+
+// unitVersions returns the versions the running units of an app belong to
+func unitVersions(app *shipa.App) map[string]bool {
+	versions := make(map[string]bool)
+	for _, unit := range app.Units {
+		if unit.Version != "" {
+			versions[unit.Version] = true
+		}
+	}
+	return versions
+}
+
+func (s *ShipaHandler) release(ctx context.Context, myKeptn *keptnv2.Keptn, data *keptnv2.ReleaseTriggeredEventData) error {
+	ctx = shipa.ContextWithLogger(ctx, s.log)
+
+	s.log.Info("1. Send Release.Started Cloud-Event")
+	myKeptn.SendTaskStartedEvent(data, ServiceName)
+
+	appName := shipaAppName(data.Project, data.Stage, data.Service)
+	progress := statusChangedReporter(myKeptn)
+
+	result := keptnv2.ResultPass
+	var action string
+	if data.Result == keptnv2.ResultFailed {
+		s.log.Info("2. Abort release and revert Shipa app")
+		result = keptnv2.ResultFailed
+
+		target, err := s.revert(ctx, myKeptn, appName, progress)
+		if err != nil {
+			return sendTaskErrored(myKeptn, err)
+		}
+		action = fmt.Sprintf("Release aborted after failed evaluation, reverted to version %s (%s)", target.Version, target.Image)
+	} else {
+		s.log.Info("2. Finish rollout of Shipa app")
+		finished, err := s.finishRollout(ctx, appName, data.Deployment.DeploymentStrategy, progress)
+		if err != nil {
+			return sendTaskErrored(myKeptn, err)
+		}
+		action = "Released"
+		if finished {
+			action = "Waited for the remaining traffic to shift to the new version and released"
+		}
+	}
+
+	deployments, err := s.client.ListAppDeployments(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to list app deployments", "error", err)
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	active, err := activeDeployment(deployments)
+	if err != nil {
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	s.log.Info("3. Send Release.Finished Cloud-Event")
+	myKeptn.SendTaskFinishedEvent(&keptnv2.ReleaseFinishedEventData{
+		EventData: keptnv2.EventData{
+			Status:  keptnv2.StatusSucceeded,
+			Result:  result,
+			Message: fmt.Sprintf("%s: version %s (%s) of Shipa app %s is active", action, active.Version, active.Image, appName),
+		},
+		Release: keptnv2.ReleaseData{
+			GitCommit: active.Commit,
+		},
+	}, ServiceName)
+
+	return nil
+}
+
+// finishRollout waits for Shipa to shift the remaining traffic of a multi-step deployment to its new version, the
+// active one, until no units of other versions are left. It reports whether there was a rollout left to finish.
+func (s *ShipaHandler) finishRollout(ctx context.Context, appName, strategy string, progress progressFunc) (bool, error) {
+	if strategy == "" || strategy == StrategyDirect {
+		return false, nil
+	}
+
+	app, err := s.client.GetApp(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to get app", "error", err)
+		return false, err
+	}
+
+	// all traffic is on the new version once the units of the previous version are gone
+	versions := unitVersions(app)
+	if len(versions) <= 1 {
+		return false, nil
+	}
+
+	deployments, err := s.client.ListAppDeployments(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to list app deployments", "error", err)
+		return false, err
+	}
+	active, err := activeDeployment(deployments)
+	if err != nil {
+		return false, err
+	}
+	if active.Error != "" || !versions[active.Version] {
+		return false, fmt.Errorf("version %s of the rollout isn't running: %s", active.Version, active.Error)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, deploymentTimeout)
+	defer cancel()
+	ticker := time.NewTicker(deploymentPollInterval)
+	defer ticker.Stop()
+
+	for len(versions) > 1 || !versions[active.Version] {
+		progress(fmt.Sprintf("Waiting for the remaining traffic to shift to version %s", active.Version))
+		select {
+		case <-ctx.Done():
+			return false, waitError(ctx, appName)
+		case <-ticker.C:
+		}
+
+		app, err := s.client.GetApp(ctx, appName)
+		if err != nil {
+			s.log.Errorw("failed to get app", "error", err)
+			return false, err
+		}
+		versions = unitVersions(app)
+	}
+
+	return true, nil
+}
+
+// revert rolls the app back to the deployment before the current one and returns the restored deployment
+func (s *ShipaHandler) revert(ctx context.Context, myKeptn *keptnv2.Keptn, appName string, progress progressFunc) (*shipa.AppDeployment, error) {
+	deployments, err := s.client.ListAppDeployments(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to list app deployments", "error", err)
+		return nil, err
+	}
+
+	target, err := rollbackTarget(deployments)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.rollout(ctx, appName, progress, func(ctx context.Context) error {
+		err := s.client.RollbackApp(ctx, appName, &shipa.AppRollback{
+			Image:  target.Image,
+			Reason: revertReason(myKeptn.CloudEvent.Type(), myKeptn.KeptnContext),
+		})
+		if err != nil {
+			s.log.Errorw("failed to roll back app", "error", err)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return target, nil
+}
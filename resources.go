@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+
+	"github.com/keptn/go-utils/pkg/api/models"
+	api "github.com/keptn/go-utils/pkg/api/utils"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// getKeptnResource returns the content of a service resource from the config repo of the event's project/stage/service.
+// When running locally the resource is read from the local file system instead.
+// A missing resource is reported with found=false rather than an error.
+func getKeptnResource(myKeptn *keptnv2.Keptn, resourceURI string) (content []byte, found bool, err error) {
+	if myKeptn.UseLocalFileSystem {
+		content, err = ioutil.ReadFile(resourceURI)
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		return content, err == nil, err
+	}
+
+	return resourceContent(myKeptn.ResourceHandler.GetServiceResource(myKeptn.Event.GetProject(), myKeptn.Event.GetStage(), myKeptn.Event.GetService(), resourceURI))
+}
+
+// resourceContent returns the content of a resource fetched from the configuration service.
+// A missing resource is reported with found=false rather than an error.
+func resourceContent(resource *models.Resource, err error) ([]byte, bool, error) {
+	if err == api.ResourceNotFoundError {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return []byte(resource.ResourceContent), true, nil
+}
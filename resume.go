@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// resumeTasks queues the tasks the previous instance left unfinished in the journal to the workers, ahead of
+// the events received from now on
+func resumeTasks(ctx context.Context) {
+	entries, err := journal.entries()
+	if err != nil {
+		logger.Errorw("failed to read task journal, not resuming tasks", "error", err)
+		return
+	}
+	if len(entries) > 0 {
+		logger.Infow("Resuming tasks left unfinished by the previous instance", "tasks", len(entries))
+	}
+
+	for _, entry := range entries {
+		err := resumeTask(ctx, entry)
+		if err != nil {
+			logger.Errorw("failed to resume task", "key", entry.key, "event_id", entry.Event.ID(), "error", err)
+			tasksResumed.WithLabelValues(taskResumeErrored).Inc()
+		}
+	}
+}
+
+// resumeTask queues an unfinished task like a received event, the worker finishes it with the outcome of its
+// Shipa rollout
+func resumeTask(ctx context.Context, entry *journalEntry) (err error) {
+	event := entry.Event
+	ctx, span := startEventSpan(ctx, event)
+	span.SetAttributes(attribute.Bool("keptn.resumed", true))
+	defer func() {
+		if err != nil {
+			endSpan(span, err)
+		}
+	}()
+
+	myKeptn, err := newEventKeptn(ctx, span, event)
+	if err != nil {
+		journal.remove(entry.key)
+		return err
+	}
+
+	// the task has finished if the deduplicator remembers the event as handled
+	key := dedupKey(myKeptn)
+	if _, isNew := deduplicator.begin(key); !isNew {
+		eventLogger(myKeptn).Info("Task in journal was already finished")
+		journal.remove(key)
+		span.End()
+		return nil
+	}
+
+	return queueTask(ctx, span, myKeptn, key, func(ctx context.Context) error {
+		err := finishResumedTask(ctx, myKeptn, entry)
+		if err != nil {
+			tasksResumed.WithLabelValues(taskResumeErrored).Inc()
+		} else {
+			tasksResumed.WithLabelValues(taskResumeSucceeded).Inc()
+		}
+		return err
+	})
+}
+
+// finishResumedTask sends the .finished event of a task left unfinished. Tasks that started a Shipa rollout
+// are finished with its outcome, the others are finished as errored.
+func finishResumedTask(ctx context.Context, myKeptn *keptnv2.Keptn, entry *journalEntry) error {
+	ensureEventLabels(myKeptn)
+	if entry.App == "" {
+		eventLogger(myKeptn).Warn("Finishing task interrupted by a restart")
+		return sendTaskErrored(myKeptn, fmt.Errorf("%s restarted before the task started a Shipa deployment", ServiceName))
+	}
+
+	s, err := NewShipaHandler(myKeptn)
+	if err != nil {
+		return sendTaskErrored(myKeptn, err)
+	}
+	return s.resume(ctx, myKeptn, entry)
+}
+
+// resume waits for the Shipa rollout recorded in the journal entry of a task and finishes the task with its outcome
+func (s *ShipaHandler) resume(ctx context.Context, myKeptn *keptnv2.Keptn, entry *journalEntry) (err error) {
+	ctx = shipa.ContextWithLogger(ctx, s.log)
+
+	s.log.Infow("Resuming task interrupted by a restart", "app", entry.App, "deployment_id", entry.DeploymentID)
+	ctx, span := tracer.Start(ctx, "resume rollout", trace.WithAttributes(attribute.String("shipa.app", entry.App)))
+	defer func() { endSpan(span, err) }()
+
+	// the rollout gets what is left of deploymentTimeout, but Shipa is checked at least once
+	deadline := entry.RolloutStarted.Add(deploymentTimeout)
+	if min := time.Now().Add(deploymentPollInterval); deadline.Before(min) {
+		deadline = min
+	}
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	deploymentsInFlight.Inc()
+	defer deploymentsInFlight.Dec()
+
+	known, err := s.resumedKnown(ctx, entry)
+	if err != nil {
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	deployment, err := s.waitForDeployment(ctx, entry.App, known, statusChangedReporter(myKeptn))
+	if err != nil {
+		s.log.Errorw("failed to wait for resumed app deployment", "error", err)
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	eventData := keptnv2.EventData{
+		Status:  keptnv2.StatusSucceeded,
+		Result:  keptnv2.ResultPass,
+		Message: fmt.Sprintf("Resumed after a restart: version %s (%s) of Shipa app %s is active", deployment.Version, deployment.Image, entry.App),
+	}
+	var data keptn.EventProperties = &eventData
+
+	switch myKeptn.CloudEvent.Type() {
+	case keptnv2.GetTriggeredEventType(keptnv2.DeploymentTaskName):
+		triggered := &keptnv2.DeploymentTriggeredEventData{}
+		if err := myKeptn.CloudEvent.DataAs(triggered); err != nil {
+			return sendTaskErrored(myKeptn, err)
+		}
+		uris, err := s.deploymentURIs(ctx, entry.App)
+		if err != nil {
+			return sendTaskErrored(myKeptn, err)
+		}
+		eventData.Labels = deployLogLabels(entry.App)
+		data = &keptnv2.DeploymentFinishedEventData{
+			EventData: eventData,
+			Deployment: keptnv2.DeploymentFinishedData{
+				DeploymentStrategy:   triggered.Deployment.DeploymentStrategy,
+				DeploymentURIsLocal:  uris,
+				DeploymentURIsPublic: uris,
+				DeploymentNames:      []string{entry.App},
+			},
+		}
+	case keptnv2.GetTriggeredEventType(keptnv2.ReleaseTaskName):
+		triggered := &keptnv2.ReleaseTriggeredEventData{}
+		if err := myKeptn.CloudEvent.DataAs(triggered); err != nil {
+			return sendTaskErrored(myKeptn, err)
+		}
+		// a release after a failed evaluation reverts the app and keeps the failed result
+		if triggered.Result == keptnv2.ResultFailed {
+			eventData.Result = keptnv2.ResultFailed
+		}
+		data = &keptnv2.ReleaseFinishedEventData{
+			EventData: eventData,
+			Release: keptnv2.ReleaseData{
+				GitCommit: deployment.Commit,
+			},
+		}
+	case keptnv2.GetTriggeredEventType(keptnv2.RollbackTaskName):
+		data = &keptnv2.RollbackFinishedEventData{EventData: eventData}
+	}
+
+	s.log.Info("Send .finished Cloud-Event of resumed task")
+	_, err = myKeptn.SendTaskFinishedEvent(data, ServiceName)
+	return err
+}
+
+// resumedKnown returns the IDs of the deployments of the app that the resumed rollout doesn't wait for. If the
+// journal has the ID of the new deployment, those are all others, so later deployments are not mistaken for it.
+func (s *ShipaHandler) resumedKnown(ctx context.Context, entry *journalEntry) (map[string]bool, error) {
+	known := make(map[string]bool)
+	if entry.DeploymentID == "" {
+		for _, id := range entry.Known {
+			known[id] = true
+		}
+		return known, nil
+	}
+
+	deployments, err := s.client.ListAppDeployments(ctx, entry.App)
+	if err != nil {
+		s.log.Errorw("failed to list app deployments", "error", err)
+		return nil, err
+	}
+
+	found := false
+	for _, deployment := range deployments {
+		if deployment.ID == entry.DeploymentID {
+			found = true
+		} else {
+			known[deployment.ID] = true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("deployment %s of app %s no longer exists", entry.DeploymentID, entry.App)
+	}
+	return known, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// originRollback is the origin of deployments created by a rollback
+const originRollback = "rollback"
+
+// rollbackTarget returns the latest deployment before the active one that Shipa can roll back to, skipping
+// errored ones. Deployments are expected newest first, as returned by ListAppDeployments.
+func rollbackTarget(deployments []*shipa.AppDeployment) (*shipa.AppDeployment, error) {
+	if len(deployments) == 0 {
+		return nil, errors.New("app has no deployments")
+	}
+
+	current, err := activeDeployment(deployments)
+	if err != nil {
+		return nil, err
+	}
+	previous := false
+	for _, deployment := range deployments {
+		if deployment == current {
+			previous = true
+			continue
+		}
+		if previous && deployment.CanRollback && deployment.Error == "" && deployment.Image != current.Image {
+			return deployment, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no previous deployment to roll back to from version %s", current.Version)
+}
+
+// revertReason is the reason of the rollbacks started by the task of an event type in a Keptn context
+func revertReason(eventType, keptnContext string) string {
+	return fmt.Sprintf("Keptn %s %s", eventType, keptnContext)
+}
+
+// revertedByRelease reports whether a deployment is the rollback the release task of the Keptn context started.
+// Shipa records the reason of a rollback as the message of the deployment it creates.
+func revertedByRelease(deployment *shipa.AppDeployment, keptnContext string) bool {
+	return deployment.Origin == originRollback &&
+		deployment.Message == revertReason(keptnv2.GetTriggeredEventType(keptnv2.ReleaseTaskName), keptnContext)
+}
+
+func (s *ShipaHandler) rollback(ctx context.Context, myKeptn *keptnv2.Keptn, data *keptnv2.RollbackTriggeredEventData) error {
+	ctx = shipa.ContextWithLogger(ctx, s.log)
+
+	s.log.Info("1. Send Rollback.Started Cloud-Event")
+	myKeptn.SendTaskStartedEvent(data, ServiceName)
+
+	s.log.Info("2. Roll back Shipa app")
+	appName := shipaAppName(data.Project, data.Stage, data.Service)
+
+	deployments, err := s.client.ListAppDeployments(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to list app deployments", "error", err)
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	// the release task of the same Keptn context reverts failed releases, in which case there is nothing left
+	// to roll back as long as its rollback is the active deployment
+	var message string
+	if active, _ := activeDeployment(deployments); active != nil && revertedByRelease(active, myKeptn.KeptnContext) {
+		message = fmt.Sprintf("Shipa app %s was already rolled back to version %s (%s) by the release task", appName, active.Version, active.Image)
+	} else {
+		restored, err := s.revert(ctx, myKeptn, appName, statusChangedReporter(myKeptn))
+		if err != nil {
+			return sendTaskErrored(myKeptn, err)
+		}
+		message = fmt.Sprintf("Rolled back Shipa app %s to version %s (%s)", appName, restored.Version, restored.Image)
+	}
+
+	s.log.Info("3. Send Rollback.Finished Cloud-Event")
+	myKeptn.SendTaskFinishedEvent(&keptnv2.RollbackFinishedEventData{
+		EventData: keptnv2.EventData{
+			Status:  keptnv2.StatusSucceeded,
+			Result:  keptnv2.ResultPass,
+			Message: message,
+		},
+	}, ServiceName)
+
+	return nil
+}
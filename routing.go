@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"gopkg.in/yaml.v2"
+)
+
+// targetsFile is the project resource in the Keptn config repo routing the stages of the project to Shipa targets
+const targetsFile = "shipa-keptn/targets.yaml"
+
+// ShipaTarget - Shipa installation and defaults used for the apps of a stage
+type ShipaTarget struct {
+	ShipaCredentials `yaml:",inline"`
+	// TokenRef is the key of the token in the Kubernetes secret mounted at SHIPA_CREDENTIALS_DIR
+	TokenRef  string `yaml:"tokenRef,omitempty"`
+	Framework string `yaml:"framework,omitempty"`
+	Team      string `yaml:"team,omitempty"`
+}
+
+// TargetsConfig - content of shipa-keptn/targets.yaml, mapping stage names to Shipa targets
+type TargetsConfig struct {
+	Stages map[string]*ShipaTarget `yaml:"stages"`
+}
+
+// resolveShipaTarget returns the Shipa target for the event's project and stage.
+// The credentials resolved by shipaCredentialsFor are overridden by the stage's entry in shipa-keptn/targets.yaml.
+func resolveShipaTarget(myKeptn *keptnv2.Keptn) (*ShipaTarget, error) {
+	credentials, err := shipaCredentialsFor(myKeptn)
+	if err != nil {
+		return nil, err
+	}
+
+	target := &ShipaTarget{ShipaCredentials: *credentials}
+
+	route, err := stageTarget(myKeptn)
+	if err != nil {
+		return nil, err
+	}
+	if route != nil {
+		if err := target.route(myKeptn.Event.GetStage(), route); err != nil {
+			return nil, err
+		}
+	}
+
+	if target.Host == "" || target.Token == "" {
+		return nil, fmt.Errorf("no Shipa credentials configured, set SHIPA_HOST and SHIPA_TOKEN, mount a secret at SHIPA_CREDENTIALS_DIR or add %s or %s to the config repo", credentialsFile, targetsFile)
+	}
+
+	return target, nil
+}
+
+// stageTarget returns the entry of the event's stage in shipa-keptn/targets.yaml, or nil if there is none
+func stageTarget(myKeptn *keptnv2.Keptn) (*ShipaTarget, error) {
+	if myKeptn == nil || myKeptn.UseLocalFileSystem {
+		return nil, nil
+	}
+
+	project, stage := myKeptn.Event.GetProject(), myKeptn.Event.GetStage()
+	if project == "" || stage == "" {
+		return nil, nil
+	}
+
+	content, found, err := resourceContent(myKeptn.ResourceHandler.GetProjectResource(project, targetsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s of project %s: %w", targetsFile, project, err)
+	}
+	if !found {
+		return nil, nil
+	}
+
+	config := &TargetsConfig{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s of project %s: %w", targetsFile, project, err)
+	}
+
+	return config.Stages[stage], nil
+}
+
+// route overrides the target with the entry of a stage in shipa-keptn/targets.yaml. A stage routed to another host
+// needs a tokenRef, so that the token of the default host isn't sent to it.
+func (t *ShipaTarget) route(stage string, route *ShipaTarget) error {
+	if route.Host != "" && route.Host != t.Host && route.TokenRef == "" {
+		return fmt.Errorf("stage %s is routed to Shipa host %s without a tokenRef", stage, route.Host)
+	}
+
+	if route.TokenRef != "" {
+		token, err := readSecretKey(shipaCredentialsDir, route.TokenRef)
+		if err != nil {
+			return err
+		}
+		if token == "" {
+			return fmt.Errorf("token %q of stage %s not found in %s", route.TokenRef, stage, shipaCredentialsDir)
+		}
+		t.Token = token
+	}
+	t.merge(&route.ShipaCredentials)
+	t.Framework = route.Framework
+	t.Team = route.Team
+	return nil
+}
+
+// applyDefaults sets the framework and team of the target on an app that doesn't specify them
+func (t *ShipaTarget) applyDefaults(app *shipa.App) {
+	if app.Pool == "" {
+		app.Pool = t.Framework
+	}
+	if app.TeamOwner == "" {
+		app.TeamOwner = t.Team
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+)
+
+// Tests that missing apps are created in the framework and team of the stage's Shipa target
+func TestEnsureApp(t *testing.T) {
+	var created *shipa.App
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps", func(w http.ResponseWriter, r *http.Request) {
+		created = &shipa.App{}
+		json.NewDecoder(r.Body).Decode(created)
+		w.WriteHeader(http.StatusCreated)
+	})
+	mux.HandleFunc("/apps/sockshop-dev-carts", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(&shipa.App{Name: "sockshop-dev-carts"})
+	})
+	mux.HandleFunc("/apps/", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "App not found", http.StatusNotFound)
+	})
+	handler := newFakeShipaHandler(t, mux)
+	handler.target = ShipaTarget{Framework: "keptn-staging", Team: "keptn"}
+
+	if err := handler.ensureApp(context.Background(), "sockshop-dev-carts"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if created != nil {
+		t.Errorf("Expected existing app not to be created again")
+	}
+
+	if err := handler.ensureApp(context.Background(), "sockshop-staging-carts"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if created == nil {
+		t.Fatalf("Expected app sockshop-staging-carts to be created")
+	}
+	if created.Name != "sockshop-staging-carts" || created.Pool != "keptn-staging" || created.TeamOwner != "keptn" {
+		t.Errorf("Unexpected app created: %+v", created)
+	}
+
+	handler.target = ShipaTarget{}
+	if err := handler.ensureApp(context.Background(), "sockshop-prod-carts"); err == nil {
+		t.Errorf("Expected an error for a missing app without a framework")
+	}
+}
+
+// Tests that a stage routed to another Shipa host gets the token it references, not the default one
+func TestRouteShipaTarget(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "prod-token"), []byte("prod"), 0600); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	credentialsDir := shipaCredentialsDir
+	shipaCredentialsDir = dir
+	defer func() { shipaCredentialsDir = credentialsDir }()
+
+	target := &ShipaTarget{ShipaCredentials: ShipaCredentials{Host: "https://dev.shipa.cloud", Token: "dev"}}
+	if err := target.route("dev", &ShipaTarget{Framework: "keptn-dev"}); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if target.Host != "https://dev.shipa.cloud" || target.Token != "dev" || target.Framework != "keptn-dev" {
+		t.Errorf("Unexpected target: %+v", target)
+	}
+
+	target = &ShipaTarget{ShipaCredentials: ShipaCredentials{Host: "https://dev.shipa.cloud", Token: "dev"}}
+	if err := target.route("prod", &ShipaTarget{ShipaCredentials: ShipaCredentials{Host: "https://prod.shipa.cloud"}}); err == nil {
+		t.Errorf("Expected an error for another host without a tokenRef, but got %+v", target)
+	}
+
+	target = &ShipaTarget{ShipaCredentials: ShipaCredentials{Host: "https://dev.shipa.cloud", Token: "dev"}}
+	route := &ShipaTarget{ShipaCredentials: ShipaCredentials{Host: "https://prod.shipa.cloud"}, TokenRef: "prod-token"}
+	if err := target.route("prod", route); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if target.Host != "https://prod.shipa.cloud" || target.Token != "prod" {
+		t.Errorf("Unexpected target: %+v", target)
+	}
+}
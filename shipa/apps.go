@@ -1,11 +1,15 @@
 package shipa
 
 import (
+	"bufio"
 	"context"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -115,6 +119,7 @@ type Unit struct {
 	HostAddr    string   `json:"HostAddr,omitempty"`
 	HostPort    string   `json:"HostPort,omitempty"`
 	Address     *Address `json:"Address,omitempty"`
+	Restarts    int64    `json:"Restarts,omitempty"`
 }
 
 // Address - part of Unit object
@@ -272,8 +277,41 @@ type AppDeploy struct {
 	ShipaYaml      string `json:"shipayaml,omitempty"`
 }
 
+// DeployLogFunc - receives the lines of a deploy log streamed by Shipa
+type DeployLogFunc func(line string)
+
 // DeployApp - sends request to deploy app with giving parameters
 func (c *Client) DeployApp(ctx context.Context, appName string, req *AppDeploy) error {
+	return c.DeployAppWithLogs(ctx, appName, req, nil)
+}
+
+// DeployAppWithLogs - deploys app and calls onLog for every line of the deploy log
+func (c *Client) DeployAppWithLogs(ctx context.Context, appName string, req *AppDeploy, onLog DeployLogFunc) error {
+	stream, err := c.DeployAppStream(ctx, appName, req)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return ReadDeployLog(stream, func(line string) {
+		c.log(ctx).Debugw("Shipa deploy log", "app", appName, "line", line)
+		if onLog != nil {
+			onLog(line)
+		}
+	})
+}
+
+// DeployAppStream - sends request to deploy app and returns the deploy log streamed by Shipa; the caller has to close it
+func (c *Client) DeployAppStream(ctx context.Context, appName string, req *AppDeploy) (io.ReadCloser, error) {
+	params, err := deployParams(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.postURLEncodedStream(withDeployRequest(ctx), params, apiAppDeploy(appName))
+}
+
+func deployParams(req *AppDeploy) (map[string]string, error) {
 	params := map[string]string{
 		"image": req.Image,
 	}
@@ -291,7 +329,7 @@ func (c *Client) DeployApp(ctx context.Context, appName string, req *AppDeploy)
 
 	interval, err := parseStepInterval(req.StepInterval)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	params["step-interval"] = interval
 
@@ -307,12 +345,46 @@ func (c *Client) DeployApp(ctx context.Context, appName string, req *AppDeploy)
 	if req.ShipaYaml != "" {
 		yamlContent, err := getShipaYamlBase64Enc(req.ShipaYaml)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		params["shipayaml"] = yamlContent
 	}
 
-	return c.postURLEncoded(ctx, params, apiAppDeploy(appName))
+	return params, nil
+}
+
+// deployLogMessage - JSON message of a streamed deploy log
+type deployLogMessage struct {
+	Message string `json:"Message"`
+	Error   string `json:"Error"`
+}
+
+// ReadDeployLog - reads a streamed deploy log line by line and calls onLog for every line.
+// JSON log messages are unwrapped; an error message ends the log and is returned as error.
+func ReadDeployLog(r io.Reader, onLog DeployLogFunc) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		text := scanner.Text()
+
+		msg := &deployLogMessage{}
+		if strings.HasPrefix(text, "{") && json.Unmarshal([]byte(text), msg) == nil {
+			if msg.Error != "" {
+				onLog(msg.Error)
+				return errors.New(msg.Error)
+			}
+			text = msg.Message
+		}
+
+		for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			if line != "" {
+				onLog(line)
+			}
+		}
+	}
+
+	return scanner.Err()
 }
 
 func getShipaYamlBase64Enc(path string) (string, error) {
@@ -342,6 +414,33 @@ func parseStepInterval(input string) (string, error) {
 	return strconv.FormatInt(int64(interval.Seconds()), 10), nil
 }
 
+// AppRollback - represents app rollback request
+type AppRollback struct {
+	Image  string `json:"image"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// RollbackApp - rolls the app back to the image of a previous deployment
+func (c *Client) RollbackApp(ctx context.Context, appName string, req *AppRollback) error {
+	params := map[string]string{
+		"image":  req.Image,
+		"origin": "rollback",
+	}
+	if req.Reason != "" {
+		params["reason"] = req.Reason
+	}
+
+	stream, err := c.postURLEncodedStream(withDeployRequest(ctx), params, apiAppRollback(appName))
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	return ReadDeployLog(stream, func(line string) {
+		c.log(ctx).Debugw("Shipa rollback log", "app", appName, "line", line)
+	})
+}
+
 // AppDeployment - represents information about app deployments
 type AppDeployment struct {
 	ID          string `json:"ID"`
@@ -357,6 +456,7 @@ type AppDeployment struct {
 	Error       string `json:"Error,omitempty"`
 	CanRollback bool   `json:"CanRollback"`
 	Org         string `json:"Org,omitempty"`
+	Duration    int64  `json:"Duration,omitempty"` // nanoseconds
 }
 
 // ListAppDeployments - lists app deployments
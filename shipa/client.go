@@ -8,12 +8,13 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // API endpoints
@@ -50,6 +51,10 @@ func apiAppDeploy(appName string) string {
 	return fmt.Sprintf("%s/%s/deploy", apiApps, appName)
 }
 
+func apiAppRollback(appName string) string {
+	return fmt.Sprintf("%s/%s/deploy/rollback", apiApps, appName)
+}
+
 func apiRolePermissions(role string) string {
 	return fmt.Sprintf("%s/%s/permissions", apiRoles, role)
 }
@@ -67,16 +72,25 @@ type Client struct {
 	HostURL    string
 	HTTPClient *http.Client
 	Token      string
-	debug      bool
+	// Retry is the policy for retrying failed requests, DefaultRetryPolicy if nil
+	Retry *RetryPolicy
+	// UserAgent is sent with every request unless empty
+	UserAgent string
+	// logger is used for requests whose context has no logger, set by WithLogger or WithDebugLogger
+	logger Logger
+	// observer receives every request attempt, set by WithRequestObserver
+	observer RequestObserver
+	// tracerProvider creates the spans of the requests, the global one if nil
+	tracerProvider trace.TracerProvider
 }
 
 // New returns a Shipa client, trying to get host and token from ENVs
-func New() (*Client, error) {
-	return NewClient(os.Getenv("SHIPA_HOST"), os.Getenv("SHIPA_TOKEN"))
+func New(opts ...Option) (*Client, error) {
+	return NewClient(os.Getenv("SHIPA_HOST"), os.Getenv("SHIPA_TOKEN"), opts...)
 }
 
-// NewClient returns a new Shipa client.
-func NewClient(host, token string) (*Client, error) {
+// NewClient returns a new Shipa client. Unless WithoutAuthProbe is given, it verifies the token with a request.
+func NewClient(host, token string, opts ...Option) (*Client, error) {
 	if host == "" {
 		return nil, errors.New("shipa client init failed: host can not be empty")
 	}
@@ -85,39 +99,69 @@ func NewClient(host, token string) (*Client, error) {
 		return nil, errors.New("shipa client init failed: token can not be empty")
 	}
 
-	c := &Client{
-		HostURL:    host,
-		HTTPClient: &http.Client{Timeout: 1500 * time.Second},
-		Token:      token,
+	options := &clientOptions{timeout: defaultTimeout}
+	for _, opt := range opts {
+		opt(options)
 	}
 
-	err := c.testAuthentication()
+	httpClient, err := options.httpClient()
 	if err != nil {
-		return nil, fmt.Errorf("shipa client auth failed: %w", err)
+		return nil, fmt.Errorf("shipa client init failed: %w", err)
+	}
+
+	c := &Client{
+		HostURL:        host,
+		HTTPClient:     httpClient,
+		Token:          token,
+		Retry:          options.retry,
+		UserAgent:      options.userAgent,
+		logger:         options.logger,
+		observer:       options.observer,
+		tracerProvider: options.tracerProvider,
+	}
+
+	if !options.skipAuthProbe {
+		err = c.testAuthentication()
+		if err != nil {
+			return nil, fmt.Errorf("shipa client auth failed: %w", err)
+		}
 	}
 
 	return c, nil
 }
 
 func (c *Client) doRequest(req *http.Request) ([]byte, int, error) {
-	req.Header.Set("Accept", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.Token)
-
-	res, err := c.HTTPClient.Do(req)
+	res, err := c.doStreamRequest(req)
 	if err != nil {
 		return nil, 0, err
 	}
-	defer closeBody(res)
+	defer c.closeBody(res)
 
 	body, err := ioutil.ReadAll(res.Body)
-	closeBody(res)
 	return body, res.StatusCode, err
 }
 
-func closeBody(res *http.Response) {
+// doStreamRequest sends the request and returns the response with its body unread; the caller has to close it
+func (c *Client) doStreamRequest(req *http.Request) (*http.Response, error) {
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	req, span := c.startSpan(req)
+
+	start := time.Now()
+	res, err := c.doWithRetry(req)
+	c.logExchange(req, res, err, start)
+	endSpan(span, res, err)
+	return res, err
+}
+
+func (c *Client) closeBody(res *http.Response) {
 	if !res.Close {
 		if err := res.Body.Close(); err != nil {
-			fmt.Println("ERR: failed to close response body:", err.Error())
+			c.log(res.Request.Context()).Errorw("failed to close response body", "error", err)
 		}
 	}
 }
@@ -134,7 +178,7 @@ func (c *Client) get(ctx context.Context, out interface{}, urlPath ...string) er
 	}
 
 	if statusCode != http.StatusOK {
-		return ErrStatus(statusCode, body)
+		return newAPIError(req, statusCode, body)
 	}
 	return json.Unmarshal(body, out)
 }
@@ -142,11 +186,6 @@ func (c *Client) get(ctx context.Context, out interface{}, urlPath ...string) er
 func (c *Client) newURLEncodedRequest(ctx context.Context, method string, params map[string]string, urlPath ...string) (*http.Request, error) {
 	URL := strings.Join(append([]string{c.HostURL}, urlPath...), "/")
 
-	if c.debug {
-		log.Printf("\n> %s: %s\n", method, URL)
-		log.Printf(">>> Payload: %+v\n", params)
-	}
-
 	data := url.Values{}
 	for key, val := range params {
 		data.Set(key, val)
@@ -159,10 +198,6 @@ func (c *Client) newRequest(ctx context.Context, method string, payload interfac
 	var body io.Reader
 	URL := strings.Join(append([]string{c.HostURL}, urlPath...), "/")
 
-	if c.debug {
-		log.Printf("\n> %s: %s\n", method, URL)
-	}
-
 	if payload != nil {
 		data, err := json.Marshal(payload)
 		if err != nil {
@@ -188,10 +223,6 @@ func (c *Client) newRequestWithParams(ctx context.Context, method string, payloa
 		URL = fmt.Sprintf("%s?%s", URL, paramsStr)
 	}
 
-	if c.debug {
-		log.Printf("\n> %s: %s\n", method, URL)
-	}
-
 	if payload != nil {
 		data, err := json.Marshal(payload)
 		if err != nil {
@@ -217,10 +248,6 @@ func (c *Client) newRequestWithParamsList(ctx context.Context, method string, pa
 		URL = fmt.Sprintf("%s?%s", URL, paramsStr)
 	}
 
-	if c.debug {
-		log.Printf("\n> %s: %s\n", method, URL)
-	}
-
 	if payload != nil {
 		data, err := json.Marshal(payload)
 		if err != nil {
@@ -232,64 +259,58 @@ func (c *Client) newRequestWithParamsList(ctx context.Context, method string, pa
 	return http.NewRequestWithContext(ctx, method, URL, body)
 }
 
-func (c *Client) updateRequest(ctx context.Context, method string, payload interface{}, urlPath ...string) ([]byte, int, error) {
+// updateRequest sends the JSON payload and returns an *APIError unless the response has one of the expected status codes
+func (c *Client) updateRequest(ctx context.Context, method string, payload interface{}, expected []int, urlPath ...string) error {
 	req, err := c.newRequest(ctx, method, payload, urlPath...)
 	if err != nil {
-		return nil, 0, err
+		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	return c.doRequest(req)
+	body, statusCode, err := c.doRequest(req)
+	if err != nil {
+		return err
+	}
+
+	for _, status := range expected {
+		if statusCode == status {
+			return nil
+		}
+	}
+	return newAPIError(req, statusCode, body)
 }
 
-func (c *Client) updateURLEncodedRequest(ctx context.Context, method string, params map[string]string, urlPath ...string) ([]byte, int, error) {
+func (c *Client) updateURLEncodedStreamRequest(ctx context.Context, method string, params map[string]string, urlPath ...string) (*http.Response, error) {
 	req, err := c.newURLEncodedRequest(ctx, method, params, urlPath...)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	return c.doRequest(req)
+	return c.doStreamRequest(req)
 }
 
 func (c *Client) post(ctx context.Context, payload interface{}, urlPath ...string) error {
-	body, statusCode, err := c.updateRequest(ctx, "POST", payload, urlPath...)
-	if err != nil {
-		return err
-	}
-
-	if statusCode != http.StatusCreated && statusCode != http.StatusOK {
-		return ErrStatus(statusCode, body)
-	}
-	return nil
+	return c.updateRequest(ctx, "POST", payload, []int{http.StatusCreated, http.StatusOK}, urlPath...)
 }
 
-func (c *Client) postURLEncoded(ctx context.Context, params map[string]string, urlPath ...string) error {
-	body, statusCode, err := c.updateURLEncodedRequest(ctx, "POST", params, urlPath...)
+// postURLEncodedStream posts URL-encoded params and returns the streamed response body; the caller has to close it
+func (c *Client) postURLEncodedStream(ctx context.Context, params map[string]string, urlPath ...string) (io.ReadCloser, error) {
+	res, err := c.updateURLEncodedStreamRequest(ctx, "POST", params, urlPath...)
 	if err != nil {
-		return err
-	}
-
-	if c.debug {
-		fmt.Println("### Deploy app RESP:", string(body))
+		return nil, err
 	}
 
-	if statusCode != http.StatusAccepted && statusCode != http.StatusCreated && statusCode != http.StatusOK {
-		return ErrStatus(statusCode, body)
+	if res.StatusCode != http.StatusAccepted && res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		defer c.closeBody(res)
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, newAPIError(res.Request, res.StatusCode, body)
 	}
-	return nil
+	return res.Body, nil
 }
 
 func (c *Client) put(ctx context.Context, payload interface{}, urlPath ...string) error {
-	body, statusCode, err := c.updateRequest(ctx, "PUT", payload, urlPath...)
-	if err != nil {
-		return err
-	}
-
-	if statusCode != http.StatusOK {
-		return ErrStatus(statusCode, body)
-	}
-	return nil
+	return c.updateRequest(ctx, "PUT", payload, []int{http.StatusOK}, urlPath...)
 }
 
 func (c *Client) delete(ctx context.Context, urlPath ...string) error {
@@ -304,7 +325,7 @@ func (c *Client) delete(ctx context.Context, urlPath ...string) error {
 	}
 
 	if statusCode != http.StatusOK {
-		return ErrStatus(statusCode, body)
+		return newAPIError(req, statusCode, body)
 	}
 	return nil
 }
@@ -327,7 +348,7 @@ func (c *Client) deleteWithParams(ctx context.Context, params []*QueryParam, url
 	}
 
 	if statusCode != http.StatusOK {
-		return ErrStatus(statusCode, body)
+		return newAPIError(req, statusCode, body)
 	}
 	return nil
 }
@@ -344,17 +365,17 @@ func (c *Client) deleteWithPayload(ctx context.Context, payload interface{}, par
 	}
 
 	if statusCode != http.StatusOK {
-		return ErrStatus(statusCode, body)
+		return newAPIError(req, statusCode, body)
 	}
 	return nil
 }
 
-// ErrStatus - returns error with status and message
-func ErrStatus(statusCode int, body []byte) error {
-	return fmt.Errorf("status: %d, body: %s", statusCode, body)
+func (c *Client) testAuthentication() error {
+	return c.Ping(context.TODO())
 }
 
-func (c *Client) testAuthentication() error {
-	_, err := c.ListPlans(context.TODO())
+// Ping - checks that the Shipa API is reachable and accepts the token
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.ListPlans(ctx)
 	return err
 }
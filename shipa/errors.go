@@ -0,0 +1,78 @@
+package shipa
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIError - error returned for an unexpected status code of the Shipa API
+type APIError struct {
+	StatusCode int
+	// Message is the error message parsed from the response body
+	Message string
+	Method  string
+	Path    string
+}
+
+func (e *APIError) Error() string {
+	if e.Method == "" {
+		return fmt.Sprintf("status: %d, message: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s %s: status: %d, message: %s", e.Method, e.Path, e.StatusCode, e.Message)
+}
+
+// newAPIError returns an *APIError for the response to req
+func newAPIError(req *http.Request, statusCode int, body []byte) *APIError {
+	return &APIError{
+		StatusCode: statusCode,
+		Message:    errorMessage(body),
+		Method:     req.Method,
+		Path:       req.URL.Path,
+	}
+}
+
+// errorMessage returns the message of a Shipa error response, which is either JSON or plain text
+func errorMessage(body []byte) string {
+	var res struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err == nil {
+		if res.Error != "" {
+			return res.Error
+		}
+		if res.Message != "" {
+			return res.Message
+		}
+	}
+
+	return strings.TrimSpace(string(body))
+}
+
+// ErrStatus - returns error with status and message
+func ErrStatus(statusCode int, body []byte) error {
+	return &APIError{StatusCode: statusCode, Message: errorMessage(body)}
+}
+
+// IsNotFound - reports whether err is an *APIError for a missing resource
+func IsNotFound(err error) bool {
+	return hasStatus(err, http.StatusNotFound)
+}
+
+// IsConflict - reports whether err is an *APIError for a resource that already exists or is locked
+func IsConflict(err error) bool {
+	return hasStatus(err, http.StatusConflict)
+}
+
+// IsUnauthorized - reports whether err is an *APIError for a missing, invalid or insufficient token
+func IsUnauthorized(err error) bool {
+	return hasStatus(err, http.StatusUnauthorized) || hasStatus(err, http.StatusForbidden)
+}
+
+func hasStatus(err error, statusCode int) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == statusCode
+}
@@ -0,0 +1,54 @@
+package shipa
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAPIError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/apps/missing":
+			http.Error(w, "App missing not found.", http.StatusNotFound)
+		case "/apps":
+			w.WriteHeader(http.StatusConflict)
+			fmt.Fprint(w, `{"Error":"app already exists"}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+	defer server.Close()
+
+	c := &Client{HostURL: server.URL, HTTPClient: server.Client(), Token: "token"}
+
+	_, err := c.GetApp(context.Background(), "missing")
+	if !IsNotFound(err) || IsConflict(err) {
+		t.Errorf("Expected a not found error, but got %v", err)
+	}
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected an *APIError, but got %T", err)
+	}
+	if apiErr.Method != http.MethodGet || apiErr.Path != "/apps/missing" || apiErr.Message != "App missing not found." {
+		t.Errorf("Unexpected error: %+v", apiErr)
+	}
+
+	err = c.CreateApp(context.Background(), &App{Name: "existing"})
+	if !IsConflict(err) {
+		t.Errorf("Expected a conflict error, but got %v", err)
+	}
+	if err.(*APIError).Message != "app already exists" {
+		t.Errorf("Expected the parsed Shipa error message, but got %q", err.(*APIError).Message)
+	}
+
+	_, err = c.ListPlans(context.Background())
+	if !IsUnauthorized(err) {
+		t.Errorf("Expected an unauthorized error, but got %v", err)
+	}
+	if IsNotFound(fmt.Errorf("wrapped: %w", err)) || !IsUnauthorized(fmt.Errorf("wrapped: %w", err)) {
+		t.Errorf("Expected wrapped errors to be classified")
+	}
+}
@@ -0,0 +1,101 @@
+package shipa
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxLoggedBody is the number of bytes of request and response bodies included in debug logs
+const maxLoggedBody = 4096
+
+// exchangeLog logs a request/response exchange at debug level once the response body is read or closed
+type exchangeLog struct {
+	io.ReadCloser
+	logger      Logger
+	fields      []interface{}
+	contentType string
+	start       time.Time
+	header      time.Duration
+	size        int64
+	head        bytes.Buffer
+	once        sync.Once
+}
+
+// requestLogFields returns the method, URL and redacted body of a request for the debug log
+func requestLogFields(req *http.Request) []interface{} {
+	fields := []interface{}{"method", req.Method, "url", req.URL.String()}
+	if req.GetBody == nil {
+		return fields
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return fields
+	}
+	defer body.Close()
+
+	data, _ := ioutil.ReadAll(io.LimitReader(body, maxLoggedBody))
+	if req.ContentLength > 0 {
+		fields = append(fields, "request_bytes", req.ContentLength)
+	}
+	return append(fields, "request_body", redactBody(req.Header.Get("Content-Type"), data))
+}
+
+// logExchange logs the exchange of req and res, which may be nil if the request failed. Nothing is read or
+// wrapped unless the logger writes debug messages.
+func (c *Client) logExchange(req *http.Request, res *http.Response, err error, start time.Time) {
+	logger := c.log(req.Context())
+	if !debugEnabled(logger) {
+		return
+	}
+	fields := requestLogFields(req)
+
+	if err != nil {
+		logger.Debugw("Shipa request failed", append(fields, "latency", time.Since(start).String(), "error", err)...)
+		return
+	}
+
+	res.Body = &exchangeLog{
+		ReadCloser:  res.Body,
+		logger:      logger,
+		fields:      append(fields, "status", res.StatusCode),
+		contentType: res.Header.Get("Content-Type"),
+		start:       start,
+		header:      time.Since(start),
+	}
+}
+
+func (l *exchangeLog) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.size += int64(n)
+	if room := maxLoggedBody - l.head.Len(); room > 0 {
+		if n < room {
+			room = n
+		}
+		l.head.Write(p[:room])
+	}
+	if err == io.EOF {
+		l.log()
+	}
+	return n, err
+}
+
+func (l *exchangeLog) Close() error {
+	l.log()
+	return l.ReadCloser.Close()
+}
+
+func (l *exchangeLog) log() {
+	l.once.Do(func() {
+		l.logger.Debugw("Shipa request", append(l.fields,
+			"latency", l.header.String(),
+			"duration", time.Since(l.start).String(),
+			"response_bytes", l.size,
+			"response_body", redactBody(l.contentType, l.head.Bytes()),
+		)...)
+	})
+}
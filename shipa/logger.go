@@ -0,0 +1,87 @@
+package shipa
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// Logger - leveled, structured logger of the client. Messages come with alternating keys and values;
+// *zap.SugaredLogger implements it.
+type Logger interface {
+	Debugw(msg string, keysAndValues ...interface{})
+	Infow(msg string, keysAndValues ...interface{})
+	Warnw(msg string, keysAndValues ...interface{})
+	Errorw(msg string, keysAndValues ...interface{})
+}
+
+// loggerKey is the context key of the logger set by ContextWithLogger
+type loggerKey struct{}
+
+// ContextWithLogger - returns a context that makes the client log requests made with it to logger.
+// This lets a client shared by several callers log with the fields of each caller.
+func ContextWithLogger(ctx context.Context, logger Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+// log returns the logger of the request context, falling back to the logger of the client
+func (c *Client) log(ctx context.Context) Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(loggerKey{}).(Logger); ok {
+			return logger
+		}
+	}
+	if c.logger != nil {
+		return c.logger
+	}
+	return stdLogger{}
+}
+
+// debugEnabled reports whether logger writes debug messages, so that the client can skip preparing them.
+// Loggers it doesn't know are assumed to write them.
+func debugEnabled(logger Logger) bool {
+	switch l := logger.(type) {
+	case stdLogger:
+		return l.debug != nil
+	case *zap.SugaredLogger:
+		return l.Desugar().Core().Enabled(zap.DebugLevel)
+	}
+	return true
+}
+
+// stdLogger logs to the standard logger, debug messages only if debug is set
+type stdLogger struct {
+	debug *log.Logger
+}
+
+func (l stdLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	if l.debug != nil {
+		l.debug.Println(formatLine("DEBUG", msg, keysAndValues))
+	}
+}
+
+func (l stdLogger) Infow(msg string, keysAndValues ...interface{}) {
+	log.Println(formatLine("INFO", msg, keysAndValues))
+}
+
+func (l stdLogger) Warnw(msg string, keysAndValues ...interface{}) {
+	log.Println(formatLine("WARN", msg, keysAndValues))
+}
+
+func (l stdLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	log.Println(formatLine("ERR", msg, keysAndValues))
+}
+
+func formatLine(level, msg string, keysAndValues []interface{}) string {
+	var b strings.Builder
+	b.WriteString(level)
+	b.WriteString(": ")
+	b.WriteString(msg)
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		fmt.Fprintf(&b, " %v=%v", keysAndValues[i], keysAndValues[i+1])
+	}
+	return b.String()
+}
@@ -0,0 +1,101 @@
+package shipa
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RequestObserver - receives the method, endpoint template (e.g. apps/:name/deploy), status code and latency
+// of every request sent to Shipa, including retried attempts. The status code is 0 if no response was received.
+type RequestObserver func(method, endpoint string, statusCode int, latency time.Duration)
+
+// nameSegment is the placeholder of the names in endpoint templates
+const nameSegment = ":name"
+
+// endpointTemplates are the paths the client sends requests to, split into segments, with nameSegment in place
+// of the names. Every path may be followed by the name of an item, e.g. apps/:name or roles/:name/user/:name.
+var endpointTemplates = splitEndpointTemplates(
+	apiClusters,
+	apiPoolsConfig,
+	apiPools,
+	apiApps,
+	apiUsers,
+	apiPlans,
+	apiTeams,
+	apiRoles,
+	apiVolumes,
+	apiVolumePlans,
+	apiAppNetworkPolicy(nameSegment),
+	apiAppDeployments(nameSegment),
+	apiAppEnvs(nameSegment),
+	apiAppCname(nameSegment),
+	apiAppDeploy(nameSegment),
+	apiAppRollback(nameSegment),
+	apiRolePermissions(nameSegment),
+	apiRoleUser(nameSegment),
+	apiVolumeBind(nameSegment),
+)
+
+func splitEndpointTemplates(paths ...string) [][]string {
+	templates := make([][]string, 0, 2*len(paths))
+	for _, path := range paths {
+		segments := strings.Split(path, "/")
+		templates = append(templates, segments, append(segments[:len(segments):len(segments)], nameSegment))
+	}
+	return templates
+}
+
+// endpoint returns the endpoint template of a request URL, with the names in its path replaced by :name
+// so that it can be used as a metric label. The names are told apart by their position in the template the
+// path matches, so that an app named like a fixed segment, e.g. deploy, is still a name. Of several matching
+// templates the one with the most fixed segments is used. Paths that match no template keep only their first
+// segment.
+func (c *Client) endpoint(u *url.URL) string {
+	path := u.Path
+	if host, err := url.Parse(c.HostURL); err == nil {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(host.Path, "/"))
+	}
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+
+	var best []string
+	bestFixed := -1
+	for _, template := range endpointTemplates {
+		if fixed, ok := matchEndpoint(template, segments); ok && fixed > bestFixed {
+			best, bestFixed = template, fixed
+		}
+	}
+	if best == nil {
+		best = make([]string, len(segments))
+		for i := range segments {
+			best[i] = nameSegment
+		}
+		best[0] = segments[0]
+	}
+	return strings.Join(best, "/")
+}
+
+// matchEndpoint reports whether the path segments match the template and how many fixed segments the template has
+func matchEndpoint(template, segments []string) (int, bool) {
+	if len(template) != len(segments) {
+		return 0, false
+	}
+	fixed := 0
+	for i, segment := range template {
+		if segment == nameSegment {
+			continue
+		}
+		if segment != segments[i] {
+			return 0, false
+		}
+		fixed++
+	}
+	return fixed, true
+}
+
+// observe reports a request attempt to the observer of the client, if any
+func (c *Client) observe(method string, u *url.URL, statusCode int, latency time.Duration) {
+	if c.observer != nil {
+		c.observer(method, c.endpoint(u), statusCode, latency)
+	}
+}
@@ -0,0 +1,56 @@
+package shipa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestObserver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/apps/my-app" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	var observed []string
+	c, err := NewClient(server.URL+"/api", "token", WithRequestObserver(func(method, endpoint string, statusCode int, latency time.Duration) {
+		observed = append(observed, method+" "+endpoint+" "+http.StatusText(statusCode))
+	}))
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	c.GetApp(context.Background(), "my-app")
+	c.ListAppDeployments(context.Background(), "my-app")
+
+	expected := []string{"GET plans OK", "GET apps/:name Not Found", "GET apps/:name/deployments OK"}
+	if len(observed) != len(expected) {
+		t.Fatalf("Expected %v, but got %v", expected, observed)
+	}
+	for i := range expected {
+		if observed[i] != expected[i] {
+			t.Errorf("Expected %q, but got %q", expected[i], observed[i])
+		}
+	}
+
+	// names are told apart by their position, also if they look like fixed segments
+	for path, expected := range map[string]string{
+		"/api/roles/admin/user/someone@example.com": "roles/:name/user/:name",
+		"/api/apps/deploy":                          "apps/:name",
+		"/api/apps/log/env":                         "apps/:name/env",
+		"/api/apps/env/deploy/rollback":             "apps/:name/deploy/rollback",
+		"/api/provisioner/clusters/deployments":     "provisioner/clusters/:name",
+		"/api/unknown/path/segments":                "unknown/:name/:name",
+	} {
+		u := httptestRequest(t, server.URL+path).URL
+		if endpoint := c.endpoint(u); endpoint != expected {
+			t.Errorf("Expected %s for %s, but got %s", expected, path, endpoint)
+		}
+	}
+}
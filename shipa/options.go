@@ -0,0 +1,192 @@
+package shipa
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// defaultTimeout is the HTTP client timeout of clients that don't set one. Deploys stream their log
+// until the rollout is complete, so it has to outlast a deployment.
+const defaultTimeout = 1500 * time.Second
+
+// Option - configures a Client created by NewClient
+type Option func(*clientOptions)
+
+type clientOptions struct {
+	timeout        time.Duration
+	transport      http.RoundTripper
+	userAgent      string
+	logger         Logger
+	retry          *RetryPolicy
+	skipAuthProbe  bool
+	caBundle       []byte
+	certificates   []tls.Certificate
+	insecure       bool
+	proxy          string
+	observer       RequestObserver
+	tracerProvider trace.TracerProvider
+}
+
+// WithTimeout - sets the timeout of every HTTP request, including streamed deploy logs
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *clientOptions) {
+		o.timeout = timeout
+	}
+}
+
+// WithTransport - sends requests through the given transport instead of a copy of http.DefaultTransport
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *clientOptions) {
+		o.transport = transport
+	}
+}
+
+// WithUserAgent - sets the User-Agent header of every request
+func WithUserAgent(userAgent string) Option {
+	return func(o *clientOptions) {
+		o.userAgent = userAgent
+	}
+}
+
+// WithDebugLogger - logs requests and deploy logs to logger, and everything else to the standard logger
+func WithDebugLogger(logger *log.Logger) Option {
+	return func(o *clientOptions) {
+		o.logger = stdLogger{debug: logger}
+	}
+}
+
+// WithLogger - logs to logger unless the request context carries a logger set by ContextWithLogger
+func WithLogger(logger Logger) Option {
+	return func(o *clientOptions) {
+		o.logger = logger
+	}
+}
+
+// WithRequestObserver - reports every request attempt to observer, e.g. to record metrics
+func WithRequestObserver(observer RequestObserver) Option {
+	return func(o *clientOptions) {
+		o.observer = observer
+	}
+}
+
+// WithTracerProvider - creates the spans of the requests with provider instead of the global tracer provider
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(o *clientOptions) {
+		o.tracerProvider = provider
+	}
+}
+
+// WithRetryPolicy - retries failed requests according to policy instead of DefaultRetryPolicy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *clientOptions) {
+		o.retry = &policy
+	}
+}
+
+// WithoutAuthProbe - skips the request NewClient sends to verify the token
+func WithoutAuthProbe() Option {
+	return func(o *clientOptions) {
+		o.skipAuthProbe = true
+	}
+}
+
+// WithCABundle - trusts the PEM encoded CA certificates in addition to the system roots
+func WithCABundle(pem []byte) Option {
+	return func(o *clientOptions) {
+		o.caBundle = pem
+	}
+}
+
+// WithClientCertificate - presents the certificate to Shipa targets that require mutual TLS
+func WithClientCertificate(cert tls.Certificate) Option {
+	return func(o *clientOptions) {
+		o.certificates = append(o.certificates, cert)
+	}
+}
+
+// WithInsecureSkipVerify - accepts any server certificate. Only meant for labs, it makes connections open to
+// man-in-the-middle attacks.
+func WithInsecureSkipVerify() Option {
+	return func(o *clientOptions) {
+		o.insecure = true
+	}
+}
+
+// WithProxy - sends requests through the proxy at proxyURL instead of the one configured by the HTTP_PROXY env vars
+func WithProxy(proxyURL string) Option {
+	return func(o *clientOptions) {
+		o.proxy = proxyURL
+	}
+}
+
+// httpClient returns the HTTP client configured by the options
+func (o *clientOptions) httpClient() (*http.Client, error) {
+	transport := o.transport
+	if o.customTLS() || o.proxy != "" {
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		t, ok := transport.(*http.Transport)
+		if !ok {
+			return nil, errors.New("TLS options or a proxy can't be used with a custom transport that is no *http.Transport")
+		}
+		t = t.Clone()
+
+		if o.customTLS() {
+			if err := o.configureTLS(t); err != nil {
+				return nil, err
+			}
+		}
+
+		if o.proxy != "" {
+			proxyURL, err := url.Parse(o.proxy)
+			if err != nil {
+				return nil, fmt.Errorf("invalid proxy URL: %w", err)
+			}
+			t.Proxy = http.ProxyURL(proxyURL)
+		}
+
+		transport = t
+	}
+
+	return &http.Client{Timeout: o.timeout, Transport: transport}, nil
+}
+
+// customTLS reports whether the options change the TLS configuration of the transport
+func (o *clientOptions) customTLS() bool {
+	return o.caBundle != nil || len(o.certificates) > 0 || o.insecure
+}
+
+// configureTLS applies the CA bundle, client certificates and insecure-skip-verify to the transport
+func (o *clientOptions) configureTLS(t *http.Transport) error {
+	if t.TLSClientConfig == nil {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	config := t.TLSClientConfig
+
+	if o.caBundle != nil {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(o.caBundle) {
+			return errors.New("CA bundle contains no PEM encoded certificates")
+		}
+		config.RootCAs = pool
+	}
+
+	config.Certificates = append(config.Certificates, o.certificates...)
+	if o.insecure {
+		config.InsecureSkipVerify = true
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package shipa
+
+import (
+	"bytes"
+	"context"
+	"encoding/pem"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewClientOptions(t *testing.T) {
+	var userAgent string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	debugLog := &bytes.Buffer{}
+
+	c, err := NewClient(server.URL, "token",
+		WithCABundle(caBundle),
+		WithUserAgent("shipa-keptn"),
+		WithDebugLogger(log.New(debugLog, "", 0)),
+		WithTimeout(time.Minute),
+		WithRetryPolicy(RetryPolicy{MaxAttempts: 1}),
+	)
+	if err != nil {
+		t.Fatalf("Expected the CA bundle to be trusted, but got %v", err)
+	}
+
+	if userAgent != "shipa-keptn" {
+		t.Errorf("Expected user agent shipa-keptn, but got %q", userAgent)
+	}
+	if !strings.Contains(debugLog.String(), "url="+server.URL+"/plans") {
+		t.Errorf("Expected the auth probe to be logged, but got %q", debugLog.String())
+	}
+	if c.HTTPClient.Timeout != time.Minute || c.Retry.MaxAttempts != 1 {
+		t.Errorf("Expected timeout and retry policy to be set")
+	}
+
+	if _, err := NewClient(server.URL, "token"); err == nil {
+		t.Errorf("Expected the self-signed certificate to be rejected without CA bundle")
+	}
+
+	if _, err := NewClient("https://shipa.invalid", "token", WithoutAuthProbe()); err != nil {
+		t.Errorf("Expected no auth probe, but got %v", err)
+	}
+
+	if _, err := NewClient(server.URL, "token", WithCABundle([]byte("no certificate")), WithoutAuthProbe()); err == nil {
+		t.Errorf("Expected an invalid CA bundle to be rejected")
+	}
+
+	if _, err := NewClient(server.URL, "token", WithTransport(roundTripFunc(nil)), WithProxy("http://proxy:3128"), WithoutAuthProbe()); err == nil {
+		t.Errorf("Expected a proxy with a custom transport to be rejected")
+	}
+
+	c, err = NewClient(server.URL, "token", WithProxy("http://proxy:3128"), WithoutAuthProbe())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	proxyURL, _ := c.HTTPClient.Transport.(*http.Transport).Proxy(httptestRequest(t, server.URL))
+	if proxyURL == nil || proxyURL.Host != "proxy:3128" {
+		t.Errorf("Expected requests to go through proxy:3128, but got %v", proxyURL)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func httptestRequest(t *testing.T, url string) *http.Request {
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
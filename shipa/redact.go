@@ -0,0 +1,80 @@
+package shipa
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces the values of sensitive fields in debug logs
+const redacted = "[REDACTED]"
+
+// sensitiveFields are the normalized names of payload fields holding secrets, e.g. AppDeploy.RegistrySecret,
+// ClusterEndpoint.ClientKey, ClusterEndpoint.Token, ClusterEndpoint.Password and User.Password
+var sensitiveFields = map[string]bool{
+	"password":       true,
+	"token":          true,
+	"clientkey":      true,
+	"registrysecret": true,
+	"secret":         true,
+	"privatekey":     true,
+}
+
+// isSensitive reports whether a field holds a secret, ignoring case, dashes and underscores
+func isSensitive(field string) bool {
+	field = strings.NewReplacer("-", "", "_", "").Replace(strings.ToLower(field))
+	return sensitiveFields[field]
+}
+
+// redactBody returns a body for the debug log with the values of sensitive fields redacted.
+// JSON and URL-encoded bodies are redacted field by field, other bodies are returned as they are.
+func redactBody(contentType string, body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+
+	if strings.HasPrefix(contentType, "application/x-www-form-urlencoded") {
+		values, err := url.ParseQuery(string(body))
+		if err == nil {
+			for field := range values {
+				if isSensitive(field) {
+					values.Set(field, redacted)
+				}
+			}
+			return values.Encode()
+		}
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		// truncated JSON can't be redacted
+		if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			return "[JSON not logged]"
+		}
+		return string(body)
+	}
+	data, err := json.Marshal(redactValue(payload))
+	if err != nil {
+		return string(body)
+	}
+	return string(data)
+}
+
+// redactValue redacts the sensitive fields of a decoded JSON value
+func redactValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for field, fieldValue := range v {
+			if isSensitive(field) {
+				v[field] = redacted
+			} else {
+				v[field] = redactValue(fieldValue)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = redactValue(v[i])
+		}
+	}
+	return value
+}
@@ -0,0 +1,82 @@
+package shipa
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestRedactBody(t *testing.T) {
+	body := `{"name":"cluster","endpoint":{"addresses":["https://k8s"],"clientKey":"key-value","token":"token-value","password":"password-value"}}`
+	redactedBody := redactBody("application/json", []byte(body))
+	for _, secret := range []string{"key-value", "token-value", "password-value"} {
+		if strings.Contains(redactedBody, secret) {
+			t.Errorf("Expected %s to be redacted, but got %s", secret, redactedBody)
+		}
+	}
+	if !strings.Contains(redactedBody, `"https://k8s"`) {
+		t.Errorf("Expected the addresses to be kept, but got %s", redactedBody)
+	}
+
+	form := redactBody("application/x-www-form-urlencoded", []byte("image=nginx&registry-secret=secret"))
+	if form != "image=nginx&registry-secret=%5BREDACTED%5D" {
+		t.Errorf("Expected registry-secret to be redacted, but got %s", form)
+	}
+
+	if truncated := redactBody("application/json", []byte(`{"token":"tok`)); strings.Contains(truncated, "tok") {
+		t.Errorf("Expected truncated JSON not to be logged, but got %s", truncated)
+	}
+}
+
+func TestLogExchange(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"name":"admin","password":"response-password"}`))
+	}))
+	defer server.Close()
+
+	debugLog := &bytes.Buffer{}
+	c, err := NewClient(server.URL, "token", WithDebugLogger(log.New(debugLog, "", 0)), WithoutAuthProbe())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	cluster := &Cluster{Name: "cluster", Endpoint: &ClusterEndpoint{ClientKey: "client-key", Token: "cluster-token", Password: "cluster-password"}}
+	if err := c.CreateCluster(context.Background(), cluster); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, err := c.DeployAppStream(context.Background(), "app", &AppDeploy{Image: "nginx", PrivateImage: true, RegistrySecret: "registry-secret"}); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	line := debugLog.String()
+	for _, secret := range []string{"client-key", "cluster-token", "cluster-password", "response-password", "=registry-secret"} {
+		if strings.Contains(line, secret) {
+			t.Errorf("Expected %s to be redacted, but got %q", secret, line)
+		}
+	}
+	for _, field := range []string{"method=POST", "status=201", "latency=", "request_bytes=", "response_bytes=47", `"name":"admin"`} {
+		if !strings.Contains(line, field) {
+			t.Errorf("Expected %s in the exchange log, but got %q", field, line)
+		}
+	}
+
+	// without a debug logger the response body is left alone
+	res := &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}
+	req := httptest.NewRequest(http.MethodGet, server.URL, nil)
+	c.logExchange(req.WithContext(ContextWithLogger(context.Background(), stdLogger{})), res, nil, time.Now())
+	if res.Body != http.NoBody {
+		t.Errorf("Expected the response body not to be wrapped without a debug logger")
+	}
+	if debugEnabled(zap.NewNop().Sugar()) {
+		t.Errorf("Expected a no-op zap logger not to write debug messages")
+	}
+}
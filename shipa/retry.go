@@ -0,0 +1,171 @@
+package shipa
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy - how requests failing with a network error, 429 or 5xx are retried
+type RetryPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, retries are disabled if it is 1 or less
+	MaxAttempts int
+	// BaseDelay is the backoff before the first retry, it doubles with every further retry
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff and the delay requested by a Retry-After header
+	MaxDelay time.Duration
+	// RetryDeploys enables retries of app deploys and rollbacks. They are POST requests, so a retry after
+	// a network error may start a second deployment.
+	RetryDeploys bool
+}
+
+// DefaultRetryPolicy - retry policy of clients that don't set one
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   500 * time.Millisecond,
+	MaxDelay:    30 * time.Second,
+}
+
+// deployRequestKey marks the context of deploy requests, which RetryPolicy.RetryDeploys applies to
+type deployRequestKey struct{}
+
+func withDeployRequest(ctx context.Context) context.Context {
+	return context.WithValue(ctx, deployRequestKey{}, true)
+}
+
+// retryable reports whether the policy allows retrying the request
+func (p *RetryPolicy) retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		deploy, _ := req.Context().Value(deployRequestKey{}).(bool)
+		return deploy && p.RetryDeploys
+	}
+	return false
+}
+
+// shouldRetry reports whether a failed attempt should be retried
+func (p *RetryPolicy) shouldRetry(req *http.Request, res *http.Response, err error, attempt int) bool {
+	if attempt >= p.MaxAttempts || !p.retryable(req) || req.Context().Err() != nil {
+		return false
+	}
+	// the body can't be sent again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+	if err != nil {
+		return !isCertificateError(err)
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+}
+
+// isCertificateError reports whether err is caused by a server certificate that isn't trusted, which retries can't fix
+func isCertificateError(err error) bool {
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalid x509.CertificateInvalidError
+	var hostname x509.HostnameError
+	return errors.As(err, &unknownAuthority) || errors.As(err, &invalid) || errors.As(err, &hostname)
+}
+
+// delay returns how long to wait before the next attempt: the delay requested by a Retry-After header,
+// or an exponential backoff with full jitter
+func (p *RetryPolicy) delay(res *http.Response, attempt int) time.Duration {
+	if res != nil {
+		if retryAfter, ok := parseRetryAfter(res.Header.Get("Retry-After")); ok {
+			return p.capped(retryAfter)
+		}
+	}
+
+	backoff := p.BaseDelay << uint(attempt-1)
+	if backoff <= 0 {
+		backoff = p.MaxDelay
+	}
+	backoff = p.capped(backoff)
+	if backoff <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
+
+func (p *RetryPolicy) capped(d time.Duration) time.Duration {
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		return p.MaxDelay
+	}
+	return d
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// retryPolicy returns the retry policy of the client
+func (c *Client) retryPolicy() *RetryPolicy {
+	if c.Retry != nil {
+		return c.Retry
+	}
+	return &DefaultRetryPolicy
+}
+
+// doWithRetry sends the request, retrying it according to the client's retry policy
+func (c *Client) doWithRetry(req *http.Request) (*http.Response, error) {
+	policy := c.retryPolicy()
+
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		start := time.Now()
+		res, err := c.HTTPClient.Do(req)
+		if err != nil {
+			c.observe(req.Method, req.URL, 0, time.Since(start))
+		} else {
+			c.observe(req.Method, req.URL, res.StatusCode, time.Since(start))
+		}
+		if !policy.shouldRetry(req, res, err, attempt) {
+			return res, err
+		}
+
+		delay := policy.delay(res, attempt)
+		if err != nil {
+			c.log(req.Context()).Warnw("Shipa request failed, retrying", "method", req.Method, "path", req.URL.Path, "attempt", attempt, "delay", delay.String(), "error", err)
+		} else {
+			c.log(req.Context()).Warnw("Shipa request failed, retrying", "method", req.Method, "path", req.URL.Path, "attempt", attempt, "delay", delay.String(), "status", res.StatusCode)
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package shipa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	attempts := map[string]int{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts[r.Method+" "+r.URL.Path]++
+		if attempts[r.Method+" "+r.URL.Path] < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	c := &Client{
+		HostURL:    server.URL,
+		HTTPClient: server.Client(),
+		Token:      "token",
+		Retry:      &RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+	}
+
+	if _, err := c.ListPlans(context.Background()); err != nil {
+		t.Errorf("Expected GET to succeed after retries, but got %v", err)
+	}
+	if attempts["GET /plans"] != 3 {
+		t.Errorf("Expected 3 attempts, but got %d", attempts["GET /plans"])
+	}
+
+	if err := c.CreateApp(context.Background(), &App{Name: "app"}); err == nil {
+		t.Errorf("Expected POST to fail without retries")
+	}
+	if attempts["POST /apps"] != 1 {
+		t.Errorf("Expected POST not to be retried, but got %d attempts", attempts["POST /apps"])
+	}
+
+	if err := c.DeployApp(context.Background(), "app", &AppDeploy{Image: "image"}); err == nil {
+		t.Errorf("Expected deploy to fail without retries")
+	}
+	c.Retry.RetryDeploys = true
+	if err := c.DeployApp(context.Background(), "app", &AppDeploy{Image: "image"}); err != nil {
+		t.Errorf("Expected deploy to succeed after opting in to retries, but got %v", err)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := &RetryPolicy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	for attempt := 1; attempt <= 5; attempt++ {
+		if delay := policy.delay(nil, attempt); delay < 0 || delay > 10*time.Second {
+			t.Errorf("Expected the backoff of attempt %d to be capped at 10s, but got %s", attempt, delay)
+		}
+	}
+
+	res := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	if delay := policy.delay(res, 1); delay != 3*time.Second {
+		t.Errorf("Expected Retry-After to be honored, but got %s", delay)
+	}
+
+	res.Header.Set("Retry-After", "120")
+	if delay := policy.delay(res, 1); delay != 10*time.Second {
+		t.Errorf("Expected Retry-After to be capped at 10s, but got %s", delay)
+	}
+}
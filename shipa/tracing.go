@@ -0,0 +1,78 @@
+package shipa
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer of the client
+const instrumentationName = "github.com/brunoa19/shipa-keptn/shipa"
+
+// tracer returns the tracer of the client, from the global tracer provider unless WithTracerProvider was given
+func (c *Client) tracer() trace.Tracer {
+	provider := c.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+// startSpan starts the client span of a request and adds its trace context to the request headers
+func (c *Client) startSpan(req *http.Request) (*http.Request, trace.Span) {
+	ctx, span := c.tracer().Start(req.Context(), "shipa "+req.Method+" "+c.endpoint(req.URL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("http.url", req.URL.String()),
+			attribute.String("shipa.endpoint", c.endpoint(req.URL)),
+		),
+	)
+
+	req = req.WithContext(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, span
+}
+
+// endSpan ends the span of a request when the response body is read or closed, as streamed deploy logs
+// take as long as the deployment
+func endSpan(span trace.Span, res *http.Response, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return
+	}
+
+	span.SetAttributes(attribute.Int("http.status_code", res.StatusCode))
+	if res.StatusCode >= http.StatusBadRequest {
+		span.SetStatus(codes.Error, http.StatusText(res.StatusCode))
+	}
+	res.Body = &tracedBody{ReadCloser: res.Body, span: span}
+}
+
+// tracedBody ends the span of a request at the end of its response body
+type tracedBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *tracedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.once.Do(func() { b.span.End() })
+	}
+	return n, err
+}
+
+func (b *tracedBody) Close() error {
+	b.once.Do(func() { b.span.End() })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,46 @@
+package shipa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestRequestTracing(t *testing.T) {
+	var traceParent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceParent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	c, err := NewClient(server.URL, "token", WithTracerProvider(provider), WithoutAuthProbe())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "event")
+	c.GetApp(ctx, "my-app")
+	parent.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != "shipa GET apps/:name" {
+		t.Fatalf("Expected the span of the request, but got %v", spans)
+	}
+	if spans[0].Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Errorf("Expected the request span to be a child of the caller's span")
+	}
+	if !strings.Contains(traceParent, spans[0].SpanContext().SpanID().String()) {
+		t.Errorf("Expected the request to carry the trace context of its span, but got %q", traceParent)
+	}
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"gopkg.in/yaml.v2"
+)
+
+// sliFile is the SLI configuration in the Keptn config repo. To add the file use:
+//
+//	keptn add-resource --project=PROJECT --stage=STAGE --service=SERVICE --resource=my-sli-config.yaml  --resourceUri=shipa-keptn/sli.yaml
+const sliFile = "shipa-keptn/sli.yaml"
+
+// SLI query metrics
+const (
+	metricUnits              = "units"
+	metricRestarts           = "restarts"
+	metricDeploymentErrors   = "deployment_errors"
+	metricDeploymentDuration = "deployment_duration"
+)
+
+// SLIConfig - content of shipa-keptn/sli.yaml, mapping indicator names to queries
+type SLIConfig struct {
+	SpecVersion string            `yaml:"spec_version,omitempty"`
+	Indicators  map[string]string `yaml:"indicators"`
+}
+
+// defaultSLIConfig is used when no shipa-keptn/sli.yaml is found for the service
+var defaultSLIConfig = SLIConfig{
+	Indicators: map[string]string{
+		"units_started":       "units(status=started)",
+		"units_error":         "units(status=error)",
+		"unit_restarts":       "restarts",
+		"deployment_errors":   "deployment_errors",
+		"deployment_duration": "deployment_duration",
+	},
+}
+
+// sliQuery - parsed SLI query of the form metric or metric(key=value,...)
+type sliQuery struct {
+	Metric  string
+	Filters map[string]string
+}
+
+func parseSLIQuery(query string) (*sliQuery, error) {
+	query = strings.TrimSpace(query)
+	q := &sliQuery{Metric: query, Filters: make(map[string]string)}
+
+	open := strings.Index(query, "(")
+	if open < 0 {
+		return q, nil
+	}
+	if !strings.HasSuffix(query, ")") {
+		return nil, fmt.Errorf("invalid query %q: missing closing parenthesis", query)
+	}
+
+	q.Metric = strings.TrimSpace(query[:open])
+	args := strings.TrimSpace(query[open+1 : len(query)-1])
+	if args == "" {
+		return q, nil
+	}
+
+	for _, arg := range strings.Split(args, ",") {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid query %q: filter %q is not of the form key=value", query, arg)
+		}
+		q.Filters[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return q, nil
+}
+
+// matchesUnit reports whether a unit matches the status and version filters of the query
+func (q *sliQuery) matchesUnit(unit *shipa.Unit) bool {
+	if status, ok := q.Filters["status"]; ok && unit.Status != status {
+		return false
+	}
+	if version, ok := q.Filters["version"]; ok && unit.Version != version {
+		return false
+	}
+	return true
+}
+
+// sliData - Shipa data the SLIs of an evaluation are computed from
+type sliData struct {
+	app         *shipa.App
+	deployments []*shipa.AppDeployment
+	start       time.Time
+	end         time.Time
+}
+
+// inTimeframe reports whether a deployment happened within the evaluation timeframe.
+// Deployments are included when either the timeframe or the deployment timestamp can't be determined.
+func (d *sliData) inTimeframe(deployment *shipa.AppDeployment) bool {
+	if d.start.IsZero() || d.end.IsZero() {
+		return true
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, deployment.Timestamp)
+	if err != nil {
+		return true
+	}
+
+	return !timestamp.Before(d.start) && !timestamp.After(d.end)
+}
+
+// value computes the value of an SLI query
+func (d *sliData) value(query string) (float64, error) {
+	q, err := parseSLIQuery(query)
+	if err != nil {
+		return 0, err
+	}
+
+	switch q.Metric {
+	case metricUnits:
+		var count float64
+		for _, unit := range d.app.Units {
+			if q.matchesUnit(unit) {
+				count++
+			}
+		}
+		return count, nil
+
+	case metricRestarts:
+		var restarts float64
+		for _, unit := range d.app.Units {
+			if q.matchesUnit(unit) {
+				restarts += float64(unit.Restarts)
+			}
+		}
+		return restarts, nil
+
+	case metricDeploymentErrors:
+		var count float64
+		for _, deployment := range d.deployments {
+			if deployment.Error != "" && d.inTimeframe(deployment) {
+				count++
+			}
+		}
+		return count, nil
+
+	case metricDeploymentDuration:
+		if len(d.deployments) == 0 {
+			return 0, fmt.Errorf("app %s has no deployments", d.app.Name)
+		}
+		return time.Duration(d.deployments[0].Duration).Seconds(), nil
+	}
+
+	return 0, fmt.Errorf("unsupported metric %q", q.Metric)
+}
+
+// sliConfig returns the SLI configuration of the service, falling back to defaultSLIConfig
+func sliConfig(myKeptn *keptnv2.Keptn) (*SLIConfig, error) {
+	content, found, err := getKeptnResource(myKeptn, sliFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch SLI file %s from config repo: %w", sliFile, err)
+	}
+	if !found {
+		eventLogger(myKeptn).Infof("No SLI file %s found, using default indicators", sliFile)
+		return &defaultSLIConfig, nil
+	}
+
+	config := &SLIConfig{}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("failed to parse SLI file %s: %w", sliFile, err)
+	}
+
+	return config, nil
+}
+
+func (s *ShipaHandler) getSLI(ctx context.Context, myKeptn *keptnv2.Keptn, data *keptnv2.GetSLITriggeredEventData) error {
+	ctx = shipa.ContextWithLogger(ctx, s.log)
+
+	s.log.Info("1. Send Get-SLI.Started Cloud-Event")
+	_, err := myKeptn.SendTaskStartedEvent(data, ServiceName)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to send task started CloudEvent (%s), aborting...", err.Error())
+		s.log.Error(errMsg)
+		return err
+	}
+
+	config, err := sliConfig(myKeptn)
+	if err != nil {
+		s.log.Error(err)
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	s.log.Info("2. Fetch SLIs from Shipa")
+	appName := shipaAppName(data.Project, data.Stage, data.Service)
+
+	app, err := s.client.GetApp(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to get app", "error", err)
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	deployments, err := s.client.ListAppDeployments(ctx, appName)
+	if err != nil {
+		s.log.Errorw("failed to list app deployments", "error", err)
+		return sendTaskErrored(myKeptn, err)
+	}
+
+	sli := &sliData{app: app, deployments: deployments}
+	sli.start, _ = time.Parse(time.RFC3339Nano, data.GetSLI.Start)
+	sli.end, _ = time.Parse(time.RFC3339Nano, data.GetSLI.End)
+
+	sliResults := []*keptnv2.SLIResult{}
+	for _, indicatorName := range data.GetSLI.Indicators {
+		sliResult := &keptnv2.SLIResult{Metric: indicatorName}
+
+		query, ok := config.Indicators[indicatorName]
+		if !ok {
+			sliResult.Message = fmt.Sprintf("no query defined for indicator %s in %s", indicatorName, sliFile)
+		} else if value, err := sli.value(query); err != nil {
+			sliResult.Message = err.Error()
+		} else {
+			sliResult.Value = value
+			sliResult.Success = true
+		}
+
+		sliResults = append(sliResults, sliResult)
+	}
+
+	// the Shipa API needs a token, so the data source is linked in the dashboard if it is configured
+	var labels map[string]string
+	if link := appLogLink(appName); link != "" {
+		labels = map[string]string{"Link to Data Source": link}
+	}
+	ensureEventLabels(myKeptn)
+
+	s.log.Info("3. Send Get-SLI.Finished Cloud-Event")
+	_, err = myKeptn.SendTaskFinishedEvent(&keptnv2.GetSLIFinishedEventData{
+		EventData: keptnv2.EventData{
+			Status: keptnv2.StatusSucceeded,
+			Result: keptnv2.ResultPass,
+			Labels: labels,
+		},
+		GetSLI: keptnv2.GetSLIFinished{
+			IndicatorValues: sliResults,
+			Start:           data.GetSLI.Start,
+			End:             data.GetSLI.End,
+		},
+	}, ServiceName)
+	if err != nil {
+		errMsg := fmt.Sprintf("Failed to send task finished CloudEvent (%s), aborting...", err.Error())
+		s.log.Error(errMsg)
+		return err
+	}
+
+	return nil
+}
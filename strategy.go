@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"gopkg.in/yaml.v2"
+)
+
+// Deployment strategies supported in the shipyard deploymentstrategy property
+const (
+	StrategyDirect    = "direct"
+	StrategyBlueGreen = "blue_green_service"
+	StrategyCanary    = "canary"
+)
+
+// deploymentConfigFile is the per-service deployment configuration in the Keptn config repo
+const deploymentConfigFile = "shipa-keptn/deployment.yaml"
+
+// StrategyConfig - rollout parameters of a Shipa deployment
+type StrategyConfig struct {
+	Steps        int64  `json:"steps,omitempty" yaml:"steps,omitempty"`
+	StepWeight   int64  `json:"stepWeight,omitempty" yaml:"stepWeight,omitempty"`
+	StepInterval string `json:"stepInterval,omitempty" yaml:"stepInterval,omitempty"`
+}
+
+// DeploymentConfig - content of shipa-keptn/deployment.yaml
+type DeploymentConfig struct {
+	Strategies map[string]*StrategyConfig `yaml:"strategies,omitempty"`
+}
+
+// deploymentTaskProperties - shipyard properties of the deployment task, forwarded in the deployment.triggered event
+type deploymentTaskProperties struct {
+	Deployment StrategyConfig `json:"deployment"`
+}
+
+var defaultStrategies = map[string]StrategyConfig{
+	StrategyDirect:    {},
+	StrategyBlueGreen: {Steps: 2, StepWeight: 50, StepInterval: "1m"},
+	StrategyCanary:    {Steps: 4, StepWeight: 25, StepInterval: "1m"},
+}
+
+// strategySteps translates a Keptn deployment strategy into Shipa rollout steps.
+// Non-empty values of the overrides are applied in order on top of the strategy defaults.
+func strategySteps(strategy string, overrides ...*StrategyConfig) (*StrategyConfig, error) {
+	if strategy == "" {
+		strategy = StrategyDirect
+	}
+
+	defaults, ok := defaultStrategies[strategy]
+	if !ok {
+		return nil, fmt.Errorf("unsupported deployment strategy: %s", strategy)
+	}
+
+	steps := defaults
+	if strategy == StrategyDirect {
+		return &steps, nil
+	}
+
+	for _, override := range overrides {
+		if override == nil {
+			continue
+		}
+		if override.Steps > 0 {
+			steps.Steps = override.Steps
+		}
+		if override.StepWeight > 0 {
+			steps.StepWeight = override.StepWeight
+		}
+		if override.StepInterval != "" {
+			steps.StepInterval = override.StepInterval
+		}
+	}
+
+	if steps.Steps < 2 {
+		return nil, fmt.Errorf("%s deployment requires at least 2 steps, got %d", strategy, steps.Steps)
+	}
+	if steps.StepWeight < 1 || steps.StepWeight > 100 {
+		return nil, fmt.Errorf("%s deployment requires a step weight between 1 and 100, got %d", strategy, steps.StepWeight)
+	}
+	if _, err := time.ParseDuration(steps.StepInterval); err != nil {
+		return nil, fmt.Errorf("%s deployment has invalid step interval %q: %w", strategy, steps.StepInterval, err)
+	}
+
+	return &steps, nil
+}
+
+// apply sets the rollout steps on the Shipa deploy request
+func (c *StrategyConfig) apply(deploy *shipa.AppDeploy) {
+	deploy.Steps = c.Steps
+	deploy.StepWeight = c.StepWeight
+	deploy.StepInterval = c.StepInterval
+}
+
+// deploymentStrategy resolves the rollout steps for a deployment.triggered event.
+// Shipyard task properties take precedence over shipa-keptn/deployment.yaml, which takes precedence over the defaults.
+func deploymentStrategy(myKeptn *keptnv2.Keptn, data *keptnv2.DeploymentTriggeredEventData) (*StrategyConfig, error) {
+	strategy := data.Deployment.DeploymentStrategy
+	if strategy == "" {
+		strategy = StrategyDirect
+	}
+
+	var fileConfig *StrategyConfig
+	content, found, err := getKeptnResource(myKeptn, deploymentConfigFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch %s: %w", deploymentConfigFile, err)
+	}
+	if found {
+		config := &DeploymentConfig{}
+		if err := yaml.Unmarshal(content, config); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", deploymentConfigFile, err)
+		}
+		fileConfig = config.Strategies[strategy]
+	}
+
+	properties := &deploymentTaskProperties{}
+	if myKeptn.CloudEvent != nil {
+		if err := myKeptn.CloudEvent.DataAs(properties); err != nil {
+			return nil, fmt.Errorf("failed to parse deployment task properties: %w", err)
+		}
+	}
+
+	return strategySteps(strategy, fileConfig, &properties.Deployment)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+)
+
+// Tests the translation of Keptn deployment strategies into Shipa rollout steps
+func TestStrategySteps(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  string
+		overrides []*StrategyConfig
+		want      StrategyConfig
+		wantErr   bool
+	}{
+		{
+			name:     "empty strategy is direct",
+			strategy: "",
+			want:     StrategyConfig{},
+		},
+		{
+			name:      "direct ignores step overrides",
+			strategy:  StrategyDirect,
+			overrides: []*StrategyConfig{{Steps: 4}},
+			want:      StrategyConfig{},
+		},
+		{
+			name:     "blue green defaults",
+			strategy: StrategyBlueGreen,
+			want:     StrategyConfig{Steps: 2, StepWeight: 50, StepInterval: "1m"},
+		},
+		{
+			name:      "later overrides win",
+			strategy:  StrategyCanary,
+			overrides: []*StrategyConfig{{Steps: 5, StepWeight: 20}, nil, {StepWeight: 10, StepInterval: "30s"}},
+			want:      StrategyConfig{Steps: 5, StepWeight: 10, StepInterval: "30s"},
+		},
+		{
+			name:      "invalid step interval",
+			strategy:  StrategyCanary,
+			overrides: []*StrategyConfig{{StepInterval: "soon"}},
+			wantErr:   true,
+		},
+		{
+			name:     "unsupported strategy",
+			strategy: "user_managed",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := strategySteps(tt.strategy, tt.overrides...)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, but got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Error: %s", err)
+			}
+			if *got != tt.want {
+				t.Errorf("Expected %+v, but got %+v", tt.want, *got)
+			}
+		})
+	}
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// abandonGracePeriod is how long the drain waits for abandoned handlers to return after cancelling them
+const abandonGracePeriod = 5 * time.Second
+
+// errShuttingDown is returned for events received while the service drains
+var errShuttingDown = errors.New(ServiceName + " is shutting down")
+
+// errTaskAbandoned is returned for events sent by handlers whose task was abandoned
+var errTaskAbandoned = errors.New("task was abandoned on shutdown")
+
+// tasks tracks the events in flight, _main drains it on shutdown
+var tasks = newTaskTracker()
+
+// taskTracker tracks the events in flight so that a shutdown can wait for them, and finish the Keptn tasks
+// of those it has to abandon
+type taskTracker struct {
+	mu      sync.Mutex
+	running map[*task]bool
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+// task is an event in flight. It sends the events of its handler and keeps track of whether the handler has
+// started and finished the Keptn task.
+type task struct {
+	mu       sync.Mutex
+	sender   keptn.EventSender
+	finisher *keptnv2.Keptn
+	// owned is set for the .triggered events of tasks this service finishes
+	owned     bool
+	started   bool
+	finished  bool
+	abandoned bool
+	cancel    context.CancelFunc
+}
+
+// resumer is implemented by the senders of tasks that are resumed after a restart if they are abandoned
+type resumer interface {
+	resumable() bool
+}
+
+// detachedContext has the values of its parent, but isn't cancelled with it. Handlers outlive the context
+// of the receiver while the service drains.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func newTaskTracker() *taskTracker {
+	return &taskTracker{running: make(map[*task]bool)}
+}
+
+// start tracks the event of myKeptn until done is called. It routes the events sent for it through the
+// tracker and returns the context of its handler, which is only cancelled if the task is abandoned.
+// Once the tracker drains, it returns errShuttingDown.
+func (t *taskTracker) start(ctx context.Context, myKeptn *keptnv2.Keptn) (context.Context, func(), error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return nil, nil, errShuttingDown
+	}
+
+	ctx, cancel := context.WithCancel(detachedContext{ctx})
+	// the finisher sends the errored .finished event of an abandoned task past the task itself. It has its own
+	// copy of the event properties, as go-utils adds the labels of outgoing events to them.
+	labels := make(map[string]string)
+	for key, value := range myKeptn.Event.GetLabels() {
+		labels[key] = value
+	}
+	finisher := *myKeptn
+	finisher.Event = &keptnv2.EventData{
+		Project: myKeptn.Event.GetProject(),
+		Stage:   myKeptn.Event.GetStage(),
+		Service: myKeptn.Event.GetService(),
+		Labels:  labels,
+	}
+	owned := myKeptn.CloudEvent != nil && eventHandlers.ownsEvent(*myKeptn.CloudEvent)
+	tk := &task{sender: myKeptn.EventSender, finisher: &finisher, owned: owned, cancel: cancel}
+	myKeptn.EventSender = tk
+
+	t.running[tk] = true
+	t.wg.Add(1)
+
+	return ctx, func() {
+		cancel()
+		t.mu.Lock()
+		delete(t.running, tk)
+		t.mu.Unlock()
+		t.wg.Done()
+	}, nil
+}
+
+// drain rejects new events and waits up to timeout for the events in flight. It then abandons the remaining ones:
+// their Keptn tasks are finished with status errored and their handlers are cancelled.
+func (t *taskTracker) drain(timeout time.Duration) {
+	t.mu.Lock()
+	t.closed = true
+	inFlight := len(t.running)
+	t.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		t.wg.Wait()
+		close(done)
+	}()
+
+	logger.Infow("Draining events in flight", "events", inFlight, "timeout", timeout.String())
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	t.mu.Lock()
+	abandoned := make([]*task, 0, len(t.running))
+	for tk := range t.running {
+		abandoned = append(abandoned, tk)
+	}
+	t.mu.Unlock()
+
+	message := fmt.Sprintf("%s shut down before the task completed and abandoned it after waiting %s. "+
+		"The Shipa operation may still be in progress.", ServiceName, timeout)
+	for _, tk := range abandoned {
+		tk.abandon(message)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(abandonGracePeriod):
+		logger.Warn("Abandoned handlers didn't return in time")
+	}
+}
+
+// SendEvent sends an event of the handler unless the task was abandoned
+func (tk *task) SendEvent(event cloudevents.Event) error {
+	tk.mu.Lock()
+	defer tk.mu.Unlock()
+	if tk.abandoned {
+		return errTaskAbandoned
+	}
+
+	switch {
+	case strings.HasSuffix(event.Type(), ".started"):
+		tk.started = true
+	case strings.HasSuffix(event.Type(), ".finished"):
+		tk.finished = true
+	}
+	return tk.sender.SendEvent(event)
+}
+
+// abandon stops sending the events of the handler, finishes the Keptn task with status errored if the handler
+// has started but not finished it, and cancels the handler. Tasks waiting for a Shipa rollout are left to the
+// next instance, which resumes them from the journal. Owned tasks that haven't started, e.g. because their event
+// is still queued, are started and finished as errored, as they were acknowledged and nobody else finishes them.
+func (tk *task) abandon(message string) {
+	tk.mu.Lock()
+	tk.abandoned = true
+	open := tk.started && !tk.finished
+	unstarted := tk.owned && !tk.started
+	tk.mu.Unlock()
+
+	log := eventLogger(tk.finisher)
+	r, ok := tk.sender.(resumer)
+	switch {
+	case open && ok && r.resumable():
+		log.Warn("Abandoning task on shutdown, it is resumed after the restart")
+	case open:
+		log.Warnw("Abandoning task on shutdown", "reason", message)
+		tk.finisher.EventSender = tk.sender
+		_, err := tk.finisher.SendTaskFinishedEvent(&keptnv2.EventData{
+			Status:  keptnv2.StatusErrored,
+			Result:  keptnv2.ResultFailed,
+			Message: message,
+		}, ServiceName)
+		if err != nil {
+			log.Errorw("failed to finish abandoned task", "error", err)
+		}
+	case unstarted:
+		log.Warnw("Abandoning task on shutdown before it started", "reason", errShuttingDown)
+		tk.finisher.EventSender = tk.sender
+		if _, err := tk.finisher.SendTaskStartedEvent(&keptnv2.EventData{}, ServiceName); err != nil {
+			log.Errorw("failed to start abandoned task", "error", err)
+			break
+		}
+		_, err := tk.finisher.SendTaskFinishedEvent(&keptnv2.EventData{
+			Status:  keptnv2.StatusErrored,
+			Result:  keptnv2.ResultFailed,
+			Message: fmt.Sprintf("%s and abandoned the task before it started", errShuttingDown),
+		}, ServiceName)
+		if err != nil {
+			log.Errorw("failed to finish abandoned task", "error", err)
+		}
+	default:
+		log.Warn("Abandoning event on shutdown")
+	}
+
+	tk.cancel()
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tests that a shutdown waits for events in flight and finishes the tasks it abandons as errored
+func TestTaskTrackerDrain(t *testing.T) {
+	tracker := newTaskTracker()
+
+	finishedKeptn, _, err := initializeTestObjects("test-events/deployment.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	finishedSender := finishedKeptn.EventSender.(*fake.EventSender)
+	_, finishedDone, err := tracker.start(context.Background(), finishedKeptn)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	finishedKeptn.SendTaskStartedEvent(&keptnv2.EventData{}, ServiceName)
+	finishedKeptn.SendTaskFinishedEvent(&keptnv2.EventData{Status: keptnv2.StatusSucceeded}, ServiceName)
+
+	abandonedKeptn, _, err := initializeTestObjects("test-events/deployment.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	abandonedSender := abandonedKeptn.EventSender.(*fake.EventSender)
+	ctx, abandonedDone, err := tracker.start(context.Background(), abandonedKeptn)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	handlerErr := make(chan error)
+	go func() {
+		defer abandonedDone()
+		abandonedKeptn.SendTaskStartedEvent(&keptnv2.EventData{}, ServiceName)
+		<-ctx.Done()
+		_, err := abandonedKeptn.SendTaskFinishedEvent(&keptnv2.EventData{Status: keptnv2.StatusErrored, Message: ctx.Err().Error()}, ServiceName)
+		handlerErr <- err
+	}()
+
+	// the finished event is still in flight, but its task is not abandoned
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		finishedDone()
+	}()
+
+	start := time.Now()
+	go tracker.drain(20 * time.Millisecond)
+	if err := <-handlerErr; err == nil {
+		t.Errorf("Expected the events of the abandoned handler to be dropped")
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Errorf("Expected the drain to wait for the events in flight")
+	}
+
+	if _, _, err := tracker.start(context.Background(), finishedKeptn); err != errShuttingDown {
+		t.Errorf("Expected events to be rejected while draining, but got %v", err)
+	}
+
+	if len(finishedSender.SentEvents) != 2 {
+		t.Errorf("Expected the completed task not to be finished again, but got %d events", len(finishedSender.SentEvents))
+	}
+
+	if len(abandonedSender.SentEvents) != 2 {
+		t.Fatalf("Expected a .started and a .finished event for the abandoned task, but got %d events", len(abandonedSender.SentEvents))
+	}
+	finished := abandonedSender.SentEvents[1]
+	data := &keptnv2.EventData{}
+	if err := finished.DataAs(data); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if finished.Type() != keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName) || data.Status != keptnv2.StatusErrored || !strings.Contains(data.Message, "shut down") {
+		t.Errorf("Expected an errored .finished event explaining the shutdown, but got %s %s %q", finished.Type(), data.Status, data.Message)
+	}
+}
+
+// Tests that a shutdown finishes the tasks of owned events still waiting for a worker as errored
+func TestTaskTrackerDrainQueued(t *testing.T) {
+	workers, tracker := eventWorkers, tasks
+	eventWorkers, tasks = newWorkerPool(1, 10), newTaskTracker()
+	defer func() { eventWorkers, tasks = workers, tracker }()
+
+	// the only worker is busy, so the events stay queued
+	busy, release := make(chan struct{}), make(chan struct{})
+	if err := eventWorkers.submit("busy", func() {
+		close(busy)
+		<-release
+	}); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	<-busy
+
+	handled := false
+	handle := func(ctx context.Context) error {
+		handled = true
+		return nil
+	}
+	senders := make(map[string]*fake.EventSender)
+	for _, file := range []string{"test-events/deployment.triggered.json", "test-events/evaluation.triggered.json"} {
+		myKeptn, _, err := initializeTestObjects(file)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		senders[file] = myKeptn.EventSender.(*fake.EventSender)
+		if err := queueTask(context.Background(), trace.SpanFromContext(context.Background()), myKeptn, file, handle); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		tasks.drain(10 * time.Millisecond)
+		close(drained)
+	}()
+	// the queued events are abandoned before the busy worker returns
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-drained
+	eventWorkers.wait()
+
+	if handled {
+		t.Errorf("Expected the queued events not to be handled after the drain")
+	}
+
+	deployment := senders["test-events/deployment.triggered.json"]
+	if len(deployment.SentEvents) != 2 {
+		t.Fatalf("Expected a .started and a .finished event for the queued task, but got %d events", len(deployment.SentEvents))
+	}
+	if deployment.SentEvents[0].Type() != keptnv2.GetStartedEventType(keptnv2.DeploymentTaskName) {
+		t.Errorf("Expected a .started event, but got %s", deployment.SentEvents[0].Type())
+	}
+	finished := deployment.SentEvents[1]
+	data := &keptnv2.EventData{}
+	if err := finished.DataAs(data); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if finished.Type() != keptnv2.GetFinishedEventType(keptnv2.DeploymentTaskName) || data.Status != keptnv2.StatusErrored || !strings.Contains(data.Message, "shutting down") {
+		t.Errorf("Expected an errored .finished event explaining the shutdown, but got %s %s %q", finished.Type(), data.Status, data.Message)
+	}
+
+	if evaluation := senders["test-events/evaluation.triggered.json"]; len(evaluation.SentEvents) != 0 {
+		t.Errorf("Expected no events for the task of another service, but got %d events", len(evaluation.SentEvents))
+	}
+}
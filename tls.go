@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+)
+
+// pemValue returns the PEM encoded value given directly or, if it is empty, read from file.
+// A missing file counts as not configured, as the files come from an optional secret.
+func pemValue(value, file string) ([]byte, error) {
+	if value != "" {
+		return []byte(value), nil
+	}
+	if file == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// shipaTLSOptions returns the Shipa client options for the CA bundle, the mTLS client certificate and
+// insecure-skip-verify configured in env
+func shipaTLSOptions(env envConfig) ([]shipa.Option, error) {
+	opts := make([]shipa.Option, 0)
+
+	caBundle, err := pemValue(env.ShipaCA, env.ShipaCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Shipa CA bundle: %w", err)
+	}
+	if caBundle != nil {
+		opts = append(opts, shipa.WithCABundle(caBundle))
+	}
+
+	cert, err := pemValue(env.ShipaClientCert, env.ShipaClientCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Shipa client certificate: %w", err)
+	}
+	key, err := pemValue(env.ShipaClientKey, env.ShipaClientKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read Shipa client key: %w", err)
+	}
+	if (cert == nil) != (key == nil) {
+		return nil, errors.New("the Shipa client certificate and key have to be configured together")
+	}
+	if cert != nil {
+		certificate, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Shipa client certificate: %w", err)
+		}
+		opts = append(opts, shipa.WithClientCertificate(certificate))
+	}
+
+	if env.ShipaInsecureSkipVerify {
+		logger.Warn("SHIPA_INSECURE_SKIP_VERIFY is set, Shipa server certificates are not verified")
+		opts = append(opts, shipa.WithInsecureSkipVerify())
+	}
+
+	return opts, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+)
+
+// newTestCertificate returns a PEM encoded self-signed certificate and key
+func newTestCertificate(t *testing.T) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "shipa-keptn"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+}
+
+// Tests that the Shipa client trusts the configured CA bundle and presents the configured client certificate
+func TestShipaTLSOptions(t *testing.T) {
+	var clientCerts int
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCerts = len(r.TLS.PeerCertificates)
+		w.Write([]byte("[]"))
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.crt")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := ioutil.WriteFile(caFile, ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, key := newTestCertificate(t)
+
+	opts, err := shipaTLSOptions(envConfig{ShipaCAFile: caFile, ShipaClientCert: string(cert), ShipaClientKey: string(key)})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, err := shipa.NewClient(server.URL, "token", opts...); err != nil {
+		t.Fatalf("Expected a TLS connection with the CA bundle and client certificate, but got %v", err)
+	}
+	if clientCerts != 1 {
+		t.Errorf("Expected the client certificate to be presented, but got %d certificates", clientCerts)
+	}
+
+	_, err = shipaTLSOptions(envConfig{ShipaClientCert: string(cert), ShipaInsecureSkipVerify: true})
+	if err == nil {
+		t.Errorf("Expected an error for a client certificate without key")
+	}
+
+	opts, err = shipaTLSOptions(envConfig{ShipaClientCert: string(cert), ShipaClientKey: string(key), ShipaInsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, err := shipa.NewClient(server.URL, "token", opts...); err != nil {
+		t.Errorf("Expected the server certificate not to be verified, but got %v", err)
+	}
+}
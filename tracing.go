@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2" // make sure to use v2 cloudevents here
+	keptn "github.com/keptn/go-utils/pkg/lib/keptn"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// CloudEvents distributed tracing extensions, see
+// https://github.com/cloudevents/spec/blob/v1.0.1/extensions/distributed-tracing.md
+const (
+	traceParentExtension = "traceparent"
+	traceStateExtension  = "tracestate"
+)
+
+// tracer creates the spans of the service. It uses the global tracer provider, set up by setupTracing.
+var tracer = otel.Tracer(ServiceName)
+
+// setupTracing installs the W3C trace context propagator and, if an OTLP endpoint is configured, a tracer provider
+// exporting spans to it. The exporter reads its configuration from the OTEL_EXPORTER_OTLP_* env vars.
+// The returned function flushes the spans that haven't been exported yet.
+func setupTracing(ctx context.Context, env envConfig) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if env.OTLPEndpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// eventCarrier carries the trace context in the traceparent and tracestate extensions of an event
+type eventCarrier struct {
+	event *cloudevents.Event
+}
+
+func (c eventCarrier) Get(key string) string {
+	value, _ := c.event.Extensions()[key].(string)
+	return value
+}
+
+func (c eventCarrier) Set(key, value string) {
+	c.event.SetExtension(key, value)
+}
+
+func (c eventCarrier) Keys() []string {
+	return []string{traceParentExtension, traceStateExtension}
+}
+
+// eventTraceContext returns ctx with the trace context of the traceparent and tracestate extensions of the event
+func eventTraceContext(ctx context.Context, event cloudevents.Event) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, eventCarrier{event: &event})
+}
+
+// startEventSpan starts the span of processing the event, continuing the trace of the event
+func startEventSpan(ctx context.Context, event cloudevents.Event) (context.Context, trace.Span) {
+	return tracer.Start(eventTraceContext(ctx, event), "process "+event.Type(),
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("keptn.event.id", event.ID()),
+			attribute.String("keptn.event.type", event.Type()),
+			attribute.String("keptn.event.source", event.Source()),
+		),
+	)
+}
+
+// endSpan ends the span, recording err if it is set
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingEventSender adds the trace context of ctx to the events it sends
+type tracingEventSender struct {
+	ctx    context.Context
+	sender keptn.EventSender
+}
+
+// SendEvent sets the traceparent and tracestate extensions of the event and sends it
+func (s *tracingEventSender) SendEvent(event cloudevents.Event) error {
+	otel.GetTextMapPropagator().Inject(s.ctx, eventCarrier{event: &event})
+	return s.sender.SendEvent(event)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/keptn/go-utils/pkg/lib/v0_2_0/fake"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tests that the span of an event continues the trace of its traceparent extension and that
+// outgoing events carry the trace context
+func TestEventTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	if _, err := setupTracing(context.Background(), envConfig{}); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	myKeptn, incomingEvent, err := initializeTestObjects("test-events/evaluation.triggered.json")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	incomingEvent.SetExtension(traceParentExtension, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	keptnOptions.UseLocalFileSystem = true
+	defer func() { keptnOptions.UseLocalFileSystem = false }()
+	if err := processKeptnCloudEvent(context.Background(), *incomingEvent); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	eventWorkers.wait()
+
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("Expected 1 span, but got %d", len(spans))
+	}
+	if spans[0].Name() != "process sh.keptn.event.evaluation.triggered" {
+		t.Errorf("Expected the span of the event, but got %s", spans[0].Name())
+	}
+	if traceID := spans[0].SpanContext().TraceID().String(); traceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the trace of the incoming event, but got %s", traceID)
+	}
+	if parentID := spans[0].Parent().SpanID().String(); parentID != "00f067aa0ba902b7" {
+		t.Errorf("Expected the span of the incoming event as parent, but got %s", parentID)
+	}
+
+	ctx := trace.ContextWithSpanContext(context.Background(), spans[0].SpanContext())
+	sender := &tracingEventSender{ctx: ctx, sender: myKeptn.EventSender}
+	if err := sender.SendEvent(*incomingEvent); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	sent := myKeptn.EventSender.(*fake.EventSender).SentEvents[0]
+	traceParent, _ := sent.Extensions()[traceParentExtension].(string)
+	if !strings.Contains(traceParent, spans[0].SpanContext().SpanID().String()) {
+		t.Errorf("Expected the outgoing event to carry the span of the event, but got %q", traceParent)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/brunoa19/shipa-keptn/shipa"
+	keptnv2 "github.com/keptn/go-utils/pkg/lib/v0_2_0"
+)
+
+// Unit status reported by Shipa for a running unit
+const unitStatusStarted = "started"
+
+var (
+	// deploymentTimeout is how long to wait for a Shipa deployment to complete, set from envConfig at startup
+	deploymentTimeout = 15 * time.Minute
+	// deploymentPollInterval is how often Shipa is polled while waiting for a deployment
+	deploymentPollInterval = 10 * time.Second
+)
+
+// progressFunc receives progress messages while waiting for a deployment
+type progressFunc func(msg string)
+
+// statusChangedReporter returns a progressFunc that sends every new progress message as a .status.changed event
+func statusChangedReporter(myKeptn *keptnv2.Keptn) progressFunc {
+	var last string
+	log := eventLogger(myKeptn)
+	return func(msg string) {
+		if msg == last {
+			return
+		}
+		last = msg
+
+		log.Info(msg)
+		_, err := myKeptn.SendTaskStatusChangedEvent(&keptnv2.EventData{
+			Status:  keptnv2.StatusSucceeded,
+			Message: msg,
+		}, ServiceName)
+		if err != nil {
+			log.Errorw("failed to send status changed event", "error", err)
+		}
+	}
+}
+
+// deploymentIDs returns the IDs of the existing deployments of an app. An app Shipa doesn't know has none yet.
+func (s *ShipaHandler) deploymentIDs(ctx context.Context, appName string) (map[string]bool, error) {
+	ids := make(map[string]bool)
+
+	deployments, err := s.client.ListAppDeployments(ctx, appName)
+	if shipa.IsNotFound(err) {
+		return ids, nil
+	}
+	if err != nil {
+		s.log.Errorw("failed to list app deployments", "error", err)
+		return nil, err
+	}
+
+	for _, deployment := range deployments {
+		ids[deployment.ID] = true
+	}
+	return ids, nil
+}
+
+// waitForDeployment polls Shipa until a deployment of the app that is not in known is active and all of its units
+// are started, or until the deployment reports an error or ctx expires
+func (s *ShipaHandler) waitForDeployment(ctx context.Context, appName string, known map[string]bool, progress progressFunc) (*shipa.AppDeployment, error) {
+	ticker := time.NewTicker(deploymentPollInterval)
+	defer ticker.Stop()
+
+	for {
+		deployment, done, err := s.deploymentProgress(ctx, appName, known, progress)
+		if ctx.Err() != nil {
+			return nil, waitError(ctx, appName)
+		}
+		if err != nil || done {
+			return deployment, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, waitError(ctx, appName)
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitError returns the error of a wait for a deployment of the app that ended because ctx is done. A canceled
+// context, e.g. a task abandoned on shutdown, interrupted the wait rather than timing it out.
+func waitError(ctx context.Context, appName string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s waiting for deployment of app %s", deploymentTimeout, appName)
+	}
+	return fmt.Errorf("interrupted waiting for deployment of app %s: %w", appName, ctx.Err())
+}
+
+func (s *ShipaHandler) deploymentProgress(ctx context.Context, appName string, known map[string]bool, progress progressFunc) (*shipa.AppDeployment, bool, error) {
+	deployments, err := s.client.ListAppDeployments(ctx, appName)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var deployment *shipa.AppDeployment
+	for _, d := range deployments {
+		if !known[d.ID] {
+			deployment = d
+			break
+		}
+	}
+
+	if deployment == nil {
+		progress(fmt.Sprintf("Waiting for Shipa to start the deployment of app %s", appName))
+		return nil, false, nil
+	}
+	journalDeployment(ctx, deployment.ID)
+
+	if deployment.Error != "" {
+		return deployment, true, fmt.Errorf("deployment %s of app %s failed: %s", deployment.ID, appName, deployment.Error)
+	}
+
+	if !deployment.Active {
+		progress(fmt.Sprintf("Rolling out version %s of app %s", deployment.Version, appName))
+		return deployment, false, nil
+	}
+
+	app, err := s.client.GetApp(ctx, appName)
+	if err != nil {
+		return deployment, false, err
+	}
+
+	started, total := unitsStarted(app, deployment.Version)
+	progress(fmt.Sprintf("%d/%d units of version %s of app %s started", started, total, deployment.Version, appName))
+
+	return deployment, total > 0 && started == total, nil
+}
+
+// unitsStarted counts the started units of an app version
+func unitsStarted(app *shipa.App, version string) (started, total int) {
+	for _, unit := range app.Units {
+		if unit.Version != "" && unit.Version != version {
+			continue
+		}
+		total++
+		if unit.Status == unitStatusStarted {
+			started++
+		}
+	}
+	return started, total
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// errQueueFull is returned for events received while the queue of the worker pool is full.
+// The distributor gets an error response and the event isn't acknowledged.
+var errQueueFull = errors.New("event queue is full")
+
+// eventWorkers handles the received events, _main sizes it from envConfig
+var eventWorkers = newWorkerPool(4, 100)
+
+// workerPool runs jobs with bounded concurrency. Jobs with the same key run one after another in the order
+// they were submitted, so that e.g. two deploys of the same Shipa app never overlap.
+type workerPool struct {
+	mu sync.Mutex
+	// pending are the jobs per key; the first one of a key is running or waiting for a worker
+	pending map[string][]func()
+	queued  int
+	limit   int
+	workers chan struct{}
+	wg      sync.WaitGroup
+}
+
+// newWorkerPool returns a pool running up to concurrency jobs at a time, which rejects jobs beyond queueSize
+// pending ones
+func newWorkerPool(concurrency, queueSize int) *workerPool {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &workerPool{
+		pending: make(map[string][]func()),
+		limit:   queueSize,
+		workers: make(chan struct{}, concurrency),
+	}
+}
+
+// submit queues job after the pending jobs of key, or returns errQueueFull. An empty key isn't serialized.
+func (p *workerPool) submit(key string, job func()) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.queued >= p.limit {
+		return errQueueFull
+	}
+	p.queued++
+	p.wg.Add(1)
+
+	if key == "" {
+		go p.run("", job)
+		return nil
+	}
+
+	p.pending[key] = append(p.pending[key], job)
+	if len(p.pending[key]) == 1 {
+		go p.run(key, job)
+	}
+	return nil
+}
+
+// run runs job once a worker is free, then starts the next pending job of key
+func (p *workerPool) run(key string, job func()) {
+	p.workers <- struct{}{}
+	job()
+	<-p.workers
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queued--
+	p.wg.Done()
+
+	if key == "" {
+		return
+	}
+	next := p.pending[key][1:]
+	if len(next) == 0 {
+		delete(p.pending, key)
+		return
+	}
+	p.pending[key] = next
+	go p.run(key, next[0])
+}
+
+// wait waits until all submitted jobs have run
+func (p *workerPool) wait() {
+	p.wg.Wait()
+}
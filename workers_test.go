@@ -0,0 +1,75 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// Tests that the worker pool bounds concurrency and queue, and runs the jobs of a key one after another in order
+func TestWorkerPool(t *testing.T) {
+	pool := newWorkerPool(2, 5)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	runningPerKey := map[string]int{}
+	order := []int{}
+	release := make(chan struct{})
+	started := make(chan struct{}, 5)
+
+	job := func(key string, i int) func() {
+		return func() {
+			mu.Lock()
+			running++
+			runningPerKey[key]++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			if runningPerKey[key] > 1 {
+				t.Errorf("Expected the jobs of %s not to overlap", key)
+			}
+			if key == "sockshop/dev/carts" {
+				order = append(order, i)
+			}
+			mu.Unlock()
+
+			started <- struct{}{}
+			<-release
+
+			mu.Lock()
+			running--
+			runningPerKey[key]--
+			mu.Unlock()
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := pool.submit("sockshop/dev/carts", job("sockshop/dev/carts", i)); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+	for _, key := range []string{"sockshop/dev/orders", ""} {
+		if err := pool.submit(key, job(key, 0)); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+	}
+	if err := pool.submit("sockshop/dev/users", job("sockshop/dev/users", 0)); err != errQueueFull {
+		t.Errorf("Expected the sixth job to be rejected, but got %v", err)
+	}
+
+	// two workers pick up jobs of different keys
+	<-started
+	<-started
+	close(release)
+	pool.wait()
+
+	if maxRunning != 2 {
+		t.Errorf("Expected 2 jobs to run at a time, but got %d", maxRunning)
+	}
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("Expected the jobs of a key to run in order, but got %v", order)
+	}
+	if err := pool.submit("sockshop/dev/users", func() {}); err != nil {
+		t.Errorf("Expected jobs to be accepted once the queue has room, but got %v", err)
+	}
+	pool.wait()
+}